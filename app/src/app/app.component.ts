@@ -9,7 +9,23 @@ import { environment } from 'src/environments/environment';
 })
 export class AppComponent {
 
+  constructor(private readonly http: HttpClient) {
+    // Tokens expire, they are refreshed regularly while the dashboard is open
+    setInterval(() => this.refreshToken(), 15 * 60 * 1000);
+  }
+
   public get token(): string | null {
     return localStorage.getItem('token');
   }
+
+  private async refreshToken() {
+    if (!this.token)
+      return;
+    try {
+      const { token } = await this.http.post<{ token: string }>(environment.production ? '/api/auth/refresh' : 'http://localhost:8081/api/auth/refresh', {}).toPromise();
+      localStorage.setItem('token', token);
+    } catch (e) {
+      console.error(e);
+    }
+  }
 }
@@ -0,0 +1,19 @@
+import { HttpErrorResponse, HttpEvent, HttpHandler, HttpInterceptor, HttpRequest } from '@angular/common/http';
+import { Injectable } from '@angular/core';
+import { Observable, throwError } from 'rxjs';
+import { catchError } from 'rxjs/operators';
+
+@Injectable()
+export class AuthInterceptor implements HttpInterceptor {
+
+  public intercept(req: HttpRequest<unknown>, next: HttpHandler): Observable<HttpEvent<unknown>> {
+    const token = localStorage.getItem('token');
+    if (token)
+      req = req.clone({ setHeaders: { Authorization: `Bearer ${token}` } });
+    return next.handle(req).pipe(catchError((e: HttpErrorResponse) => {
+      if (e.status === 401 && !req.url.endsWith('/api/auth'))
+        localStorage.removeItem('token');
+      return throwError(e);
+    }));
+  }
+}
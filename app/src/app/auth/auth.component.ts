@@ -10,6 +10,7 @@ import { environment } from 'src/environments/environment';
 })
 export class AuthComponent {
 
+  public username?: string;
   public password?: string;
 
   constructor(
@@ -21,7 +22,7 @@ export class AuthComponent {
     e.preventDefault();
     if (this.password) {
       try {
-        const { token } = await this.http.post<AuthRes>(environment.production ? '/api/auth' : 'http://localhost:8081/api/auth', { password: this.password }).toPromise();
+        const { token } = await this.http.post<AuthRes>(environment.production ? '/api/auth' : 'http://localhost:8081/api/auth', { username: this.username, password: this.password }).toPromise();
         if (token) {
           localStorage.setItem('token', token);
           this.snackbar.open('Login successful');
@@ -31,7 +32,7 @@ export class AuthComponent {
       } catch (e) {
         if (e instanceof HttpErrorResponse) {
           if (e.status === 401) {
-            this.snackbar.open("Bad password", "", { duration: 2000 });
+            this.snackbar.open("Bad username or password", "", { duration: 2000 });
           } else 
             this.snackbar.open('Error while authenticating', '', { duration: 2000 });
         } else
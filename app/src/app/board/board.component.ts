@@ -31,15 +31,22 @@ export class BoardComponent implements OnInit {
     return name.replace(/\//g, '');
   }
 
+  public async copyHookUrl(el: ContainerInfo) {
+    if (!el.HookUrl)
+      return;
+    await navigator.clipboard.writeText(el.HookUrl);
+    this.snackbar.open('Webhook url copied', '', { duration: 2000 });
+  }
+
   public async update(el: ContainerInfo) {
     el.isUpdating = true;
     try {
-      await this.http.get(environment.production ? '/hooks' + el.Names[0] : 'http://localhost:8081/hooks' + el.Names[0], { responseType: "text" as const }).toPromise();
-      this.snackbar.open('Container updated', '', { duration: 2000 });
+      const { id } = await this.http.post<JobRes>(environment.production ? `/api/containers/${el.Name}/deploy` : `http://localhost:8081/api/containers/${el.Name}/deploy`, {}).toPromise();
+      this.snackbar.open(`Update started (job ${id})`, '', { duration: 2000 });
     } catch (e) {
       if ((e as HttpErrorResponse).status < 300)
         return;
-      this.snackbar.open(`Error updating ${this.normalizeContainerNames(el.Names[0])}`, 'Close', { duration: 5000 });
+      this.snackbar.open(`Error updating ${el.Name}`, 'Close', { duration: 5000 });
       console.error(e);
     } finally {
       el.isUpdating = false;
@@ -48,7 +55,12 @@ export class BoardComponent implements OnInit {
 
 }
 type ContainerInfo = {
+  Name: string;
+  HookUrl?: string;
   Names: string[];
   Id: string;
   isUpdating: boolean;
 }
+type JobRes = {
+  id: string;
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"dockerci/src/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+type DockerHubPayload struct {
+	CallbackUrl string `json:"callback_url"`
+	PushData    struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+type DockerHubCallback struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+//Host of the Docker Hub callback urls, the callback is only sent there so a forged payload can't make us reach another host
+const dockerHubCallbackHost = "registry.hub.docker.com"
+
+//Redirects are not followed, they could lead the callback to another host
+var callbackClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+//Parse a Docker Hub webhook payload and check that the pushed repository and tag match the container image
+func parseDockerHubPayload(req *http.Request, image string) (*DockerHubPayload, error) {
+	var payload DockerHubPayload
+	if err := utils.FromJSON(req.Body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid Docker Hub payload: %v", err)
+	}
+	if payload.CallbackUrl == "" {
+		return nil, errors.New("missing callback_url in Docker Hub payload")
+	}
+	callbackUrl, err := url.Parse(payload.CallbackUrl)
+	if err != nil || callbackUrl.Scheme != "https" || callbackUrl.Host != dockerHubCallbackHost || callbackUrl.User != nil {
+		return nil, fmt.Errorf("invalid callback_url %s, expected an https url on %s", payload.CallbackUrl, dockerHubCallbackHost)
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid container image %s: %v", image, err)
+	}
+	named = reference.TagNameOnly(named)
+	tag := named.(reference.Tagged).Tag()
+	if reference.Domain(named) != "docker.io" || !isSameDockerHubRepo(reference.Path(named), payload.Repository.RepoName) || tag != payload.PushData.Tag {
+		return nil, fmt.Errorf("pushed image %s:%s does not match container image %s", payload.Repository.RepoName, payload.PushData.Tag, image)
+	}
+	return &payload, nil
+}
+
+//Official images are named library/<name> in docker references but only <name> in Docker Hub payloads
+func isSameDockerHubRepo(path string, repoName string) bool {
+	return path == repoName || path == "library/"+repoName
+}
+
+//Send the deployment state to the Docker Hub callback url
+func (payload *DockerHubPayload) sendCallback(success bool, description string) error {
+	state := "failure"
+	if success {
+		state = "success"
+	}
+	data := utils.ToJSON(DockerHubCallback{State: state, Description: description, Context: "docker-ci"})
+	res, err := callbackClient.Post(payload.CallbackUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testCallbackUrl = "https://registry.hub.docker.com/u/totodore/automate/hook/2141b5bi5i5b02bec211i4eeih0242eg11000a/"
+
+func newDockerHubRequest(body string) *http.Request {
+	return httptest.NewRequest("POST", "/hooks/dockerhub/key", strings.NewReader(body))
+}
+
+func dockerHubBody(callbackUrl string, repo string, tag string) string {
+	return `{"callback_url": "` + callbackUrl + `", "push_data": {"tag": "` + tag + `"}, "repository": {"repo_name": "` + repo + `"}}`
+}
+
+func TestParseDockerHubPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		image   string
+		wantErr bool
+	}{
+		{"matching image", dockerHubBody(testCallbackUrl, "totodore/automate", "latest"), "totodore/automate:latest", false},
+		{"implicit latest tag", dockerHubBody(testCallbackUrl, "totodore/automate", "latest"), "totodore/automate", false},
+		{"official image", dockerHubBody(testCallbackUrl, "nginx", "1.21"), "nginx:1.21", false},
+		{"official image with library prefix", dockerHubBody(testCallbackUrl, "nginx", "1.21"), "library/nginx:1.21", false},
+		{"explicit docker hub domain", dockerHubBody(testCallbackUrl, "totodore/automate", "v1"), "docker.io/totodore/automate:v1", false},
+		{"repository mismatch", dockerHubBody(testCallbackUrl, "totodore/other", "latest"), "totodore/automate:latest", true},
+		{"tag mismatch", dockerHubBody(testCallbackUrl, "totodore/automate", "dev"), "totodore/automate:latest", true},
+		{"official image mismatch", dockerHubBody(testCallbackUrl, "nginx", "latest"), "totodore/nginx:latest", true},
+		{"other registry", dockerHubBody(testCallbackUrl, "totodore/automate", "latest"), "ghcr.io/totodore/automate:latest", true},
+		{"missing callback_url", `{"push_data": {"tag": "latest"}, "repository": {"repo_name": "totodore/automate"}}`, "totodore/automate:latest", true},
+		{"http callback_url", dockerHubBody("http://registry.hub.docker.com/u/totodore/automate/hook/1/", "totodore/automate", "latest"), "totodore/automate:latest", true},
+		{"callback_url on another host", dockerHubBody("https://169.254.169.254/latest/meta-data/", "totodore/automate", "latest"), "totodore/automate:latest", true},
+		{"callback_url with a lookalike host", dockerHubBody("https://registry.hub.docker.com.evil.com/hook/", "totodore/automate", "latest"), "totodore/automate:latest", true},
+		{"callback_url with credentials", dockerHubBody("https://registry.hub.docker.com@evil.com/hook/", "totodore/automate", "latest"), "totodore/automate:latest", true},
+		{"invalid json", `{"callback_url": `, "totodore/automate:latest", true},
+	}
+	for _, test := range tests {
+		payload, err := parseDockerHubPayload(newDockerHubRequest(test.body), test.image)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: parseDockerHubPayload() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && payload.CallbackUrl != testCallbackUrl {
+			t.Errorf("%s: CallbackUrl = %q, want %q", test.name, payload.CallbackUrl, testCallbackUrl)
+		}
+	}
+}
+
+func TestSendCallback(t *testing.T) {
+	var received DockerHubCallback
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	tests := []struct {
+		success     bool
+		description string
+		state       string
+	}{
+		{true, "Deployed totodore/automate:latest", "success"},
+		{false, "Deployment failed", "failure"},
+	}
+	for _, test := range tests {
+		payload := &DockerHubPayload{CallbackUrl: server.URL}
+		if err := payload.sendCallback(test.success, test.description); err != nil {
+			t.Fatalf("sendCallback(%v) error = %v", test.success, err)
+		}
+		want := DockerHubCallback{State: test.state, Description: test.description, Context: "docker-ci"}
+		if received != want {
+			t.Errorf("sendCallback(%v) sent %+v, want %+v", test.success, received, want)
+		}
+	}
+}
+
+func TestSendCallbackErrors(t *testing.T) {
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	for _, path := range []string{"/error", "/redirect"} {
+		payload := &DockerHubPayload{CallbackUrl: server.URL + path}
+		if err := payload.sendCallback(true, ""); err == nil {
+			t.Errorf("sendCallback to %s should fail", path)
+		}
+	}
+	if redirected {
+		t.Error("sendCallback should not follow redirects")
+	}
+}
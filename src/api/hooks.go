@@ -1,9 +1,19 @@
 package api
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"time"
+	"strings"
+
+	"dockerci/src/docker"
+	"dockerci/src/store"
+	"dockerci/src/utils"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -13,30 +23,176 @@ var upgrader = websocket.Upgrader{}
 
 //Handler for webhooks
 //Trigger onRequest when a webhook is received
-//If it is a websocket request a stream is transmitted to request func
-func handleHook(w http.ResponseWriter, req *http.Request, onRequest RequestHandler) {
-	if req.URL.Scheme != "wss" && req.URL.Scheme != "ws" {
-		name := mux.Vars(req)["name"]
-		if len(name) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-		} else {
-			status, msg := onRequest(name, nil)
-			w.WriteHeader(status)
-			w.Write([]byte(msg))
+//The webhook must be called with its random key in the url or with a token allowed to deploy the container
+func (s *Server) handleHook(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	if len(name) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if key, ok := mux.Vars(req)["key"]; ok {
+		if !s.hookKeys.Verify(name, key) {
+			log.Printf("[%s] Webhook rejected: invalid webhook key", name)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Not found"))
+			return
+		}
+	} else if getRequestToken(req) == "" {
+		log.Printf("[%s] Webhook rejected: missing webhook key or token", name)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized"))
+		return
+	}
+	//Requests carrying an api token or a session token must be allowed to deploy the container
+	if getRequestToken(req) != "" {
+		principal, err := s.authenticate(req)
+		if err != nil {
+			log.Printf("[%s] Webhook rejected: %v", name, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		if !s.can(principal, store.ActionDeploy, name) {
+			log.Printf("[%s] Webhook rejected: %s is not allowed to deploy", name, principal.Identity())
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
 		}
-	} else {
+	}
+	var dockerHubPayload *DockerHubPayload
+	if container, err := docker.FindContainer(s.getContainers(), name); err == nil {
+		if container.WebhookSecret != "" {
+			secret, _, err := docker.ResolveLabel(container.WebhookSecret)
+			if err != nil {
+				log.Printf("[%s] Error while resolving webhook secret: %v", name, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if err := verifySignature(req, secret); err != nil {
+				log.Printf("[%s] Webhook rejected: %v", name, err)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Invalid signature"))
+				return
+			}
+		}
+		if container.WebhookCallback {
+			payload, err := parseDockerHubPayload(req, container.Image)
+			if err != nil {
+				log.Printf("[%s] Webhook rejected: %v", name, err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			dockerHubPayload = payload
+		}
+	}
+	s.dispatchDeployment(w, req, name, dockerHubPayload)
+}
+
+//Request a deployment and answer according to the kind of request
+//Websocket upgrades and requests accepting text/event-stream follow the deployment stream,
+//other requests get the job id right away
+func (s *Server) dispatchDeployment(w http.ResponseWriter, req *http.Request, name string, dockerHubPayload *DockerHubPayload) {
+	switch {
+	case websocket.IsWebSocketUpgrade(req):
 		c, err := upgrader.Upgrade(w, req, nil)
 		if err != nil {
 			log.Println(err)
 			return
 		}
-
 		defer c.Close()
-		name := mux.Vars(req)["name"]
-		if len(name) == 0 {
-			c.WriteControl(websocket.CloseMessage, []byte("400 Bad Request"), time.Now().Add(time.Second))
-		} else {
-			onRequest(name, c)
+		s.followDeployment(req, name, newWebsocketStream(c), dockerHubPayload)
+	case strings.Contains(req.Header.Get("Accept"), "text/event-stream"):
+		stream, err := newSSEStream(w)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
 		}
+		s.followDeployment(req, name, stream, dockerHubPayload)
+	default:
+		job, err := s.requestDeployment(name, nil, dockerHubPayload)
+		if err != nil {
+			status, msg := getRequestError(err)
+			w.WriteHeader(status)
+			w.Write([]byte(msg))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(utils.ToJSON(map[string]string{"id": job.Id}))
+	}
+}
+
+//Request a deployment and forward its events to the stream until it is over or the client leaves
+func (s *Server) followDeployment(req *http.Request, name string, stream clientStream, dockerHubPayload *DockerHubPayload) {
+	defer stream.Close("")
+	job, err := s.requestDeployment(name, stream, dockerHubPayload)
+	if err != nil {
+		_, msg := getRequestError(err)
+		stream.Close(msg)
+		return
+	}
+	select {
+	case <-job.Done():
+	case <-stream.Closed():
+	case <-req.Context().Done():
+	}
+}
+
+//Request a deployment through onRequest
+//If the webhook comes from Docker Hub its callback is sent once the deployment is over
+func (s *Server) requestDeployment(name string, stream docker.EventStream, dockerHubPayload *DockerHubPayload) (*docker.Job, error) {
+	job, err := s.onRequest(name, stream)
+	if err != nil || dockerHubPayload == nil {
+		return job, err
+	}
+	go func() {
+		job.Wait()
+		jobStatus, jobError := job.Result()
+		description := "Container " + name + " successfully updated"
+		if jobStatus != docker.JobSuccess {
+			description = "Failed to update container " + name + ": " + jobError
+		}
+		if err := dockerHubPayload.sendCallback(jobStatus == docker.JobSuccess, description); err != nil {
+			log.Printf("[%s] Error while sending Docker Hub callback: %v", name, err)
+		}
+	}()
+	return job, nil
+}
+
+//Get the http status and the message corresponding to a request error
+func getRequestError(err error) (int, string) {
+	switch {
+	case errors.Is(err, docker.ErrContainerNotFound):
+		return http.StatusBadRequest, "Container not found"
+	case errors.Is(err, docker.ErrDuplicateName):
+		return http.StatusConflict, err.Error()
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
+
+//Check the HMAC-SHA256 signature of the raw body sent by Github in the X-Hub-Signature-256 header
+//The body is restored so that it can be read again afterwards
+func verifySignature(req *http.Request, secret string) error {
+	signature := req.Header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		return errors.New("missing X-Hub-Signature-256 header")
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return errors.New("malformed X-Hub-Signature-256 header")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature mismatch")
 	}
+	return nil
 }
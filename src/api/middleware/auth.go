@@ -1,32 +1,68 @@
 package middleware
 
 import (
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
 type JWTClaims struct {
-	username string `json:"username"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
 	jwt.StandardClaims
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		_, err := getPayload(auth)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Unauthorized"))
-		} else {
-			next.ServeHTTP(w, r)
+const defaultTokenExpiration = time.Hour
+
+var signingKey []byte
+var signingKeyOnce sync.Once
+
+//Verify a JWT given as is or in an Authorization header value and return its claims
+func ParseToken(auth string) (*JWTClaims, error) {
+	return getPayload(auth)
+}
+
+//Issue a signed token for a user, it expires after TOKEN_EXPIRATION (1h by default)
+func NewToken(username string, role string) (string, error) {
+	expiration, err := time.ParseDuration(os.Getenv("TOKEN_EXPIRATION"))
+	if err != nil {
+		expiration = defaultTokenExpiration
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &JWTClaims{
+		Username: username,
+		Role:     role,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   username,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(expiration).Unix(),
+		},
+	})
+	return token.SignedString(getSigningKey())
+}
+
+//Get the key used to sign and verify tokens from PRIVATE_KEY
+//If it is not set a random key is generated, tokens are then invalidated on restart
+func getSigningKey() []byte {
+	signingKeyOnce.Do(func() {
+		if key := os.Getenv("PRIVATE_KEY"); key != "" {
+			signingKey = []byte(key)
+			return
+		}
+		log.Println("PRIVATE_KEY is not set, using a random key: sessions won't survive a restart")
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			log.Fatal("Error while generating private key:", err)
 		}
 	})
+	return signingKey
 }
 
 func getPayload(auth string) (*JWTClaims, error) {
@@ -34,10 +70,13 @@ func getPayload(auth string) (*JWTClaims, error) {
 	if auth == "" {
 		return nil, errors.New("authorization header is empty")
 	} else {
-		token := strings.SplitAfter(auth, " ")[1]
+		token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer"))
 		if token != "" {
 			payload, err := jwt.ParseWithClaims(token, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("JWT_SECRET")), nil
+				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				}
+				return getSigningKey(), nil
 			})
 			if err != nil {
 				return nil, err
@@ -45,7 +84,7 @@ func getPayload(auth string) (*JWTClaims, error) {
 			if claims, ok := payload.Claims.(*JWTClaims); ok && payload.Valid {
 				return claims, nil
 			} else {
-				return nil, err
+				return nil, errors.New("invalid token")
 			}
 		} else {
 			return nil, errors.New("authorization header is empty")
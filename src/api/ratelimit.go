@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Failed logins allowed for an ip before it is locked out
+	maxFailedLogins = 5
+	//First lockout duration, it doubles with every failed login after that
+	baseLockout = 30 * time.Second
+	maxLockout  = time.Hour
+	//Failed logins allowed across all ips during globalWindow before every login is refused
+	maxGlobalFailedLogins = 50
+	globalWindow          = time.Minute
+	//Delay advised to an ip whose attempts are all in flight
+	inFlightRetry = time.Second
+)
+
+type loginAttempts struct {
+	failures    int
+	inFlight    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+//Limit failed logins per ip with an exponential lockout and globally on a sliding window
+//Attempts being checked count as failures until they are over so that parallel requests can't exceed the limits
+type loginLimiter struct {
+	attempts       map[string]*loginAttempts
+	globalFailures []time.Time
+	globalInFlight int
+	mutex          sync.Mutex
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{attempts: make(map[string]*loginAttempts)}
+}
+
+//Check if an ip can try to login and reserve the attempt, otherwise return how long it has to wait
+//A reserved attempt must be ended with fail or succeed
+func (limiter *loginLimiter) allow(ip string) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.pruneGlobalFailures(now)
+	if len(limiter.globalFailures)+limiter.globalInFlight >= maxGlobalFailedLogins {
+		if len(limiter.globalFailures) == 0 {
+			return false, inFlightRetry
+		}
+		return false, limiter.globalFailures[0].Add(globalWindow).Sub(now)
+	}
+	attempts, ok := limiter.attempts[ip]
+	if !ok {
+		attempts = &loginAttempts{}
+		limiter.attempts[ip] = attempts
+	}
+	if attempts.lockedUntil.After(now) {
+		return false, attempts.lockedUntil.Sub(now)
+	}
+	//Once the ip has been locked out, its attempts are checked one at a time
+	allowed := maxFailedLogins - attempts.failures
+	if allowed < 1 {
+		allowed = 1
+	}
+	if attempts.inFlight >= allowed {
+		return false, inFlightRetry
+	}
+	attempts.inFlight++
+	limiter.globalInFlight++
+	return true, 0
+}
+
+//Release an attempt reserved by allow
+func (limiter *loginLimiter) release(ip string) {
+	limiter.globalInFlight--
+	if attempts, ok := limiter.attempts[ip]; ok && attempts.inFlight > 0 {
+		attempts.inFlight--
+	}
+}
+
+//Record a failed login and lock the ip out once it has failed too many times
+func (limiter *loginLimiter) fail(ip string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.release(ip)
+	limiter.globalFailures = append(limiter.globalFailures, now)
+	limiter.pruneAttempts(now)
+	attempts, ok := limiter.attempts[ip]
+	if !ok {
+		attempts = &loginAttempts{}
+		limiter.attempts[ip] = attempts
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+	if attempts.failures >= maxFailedLogins {
+		lockout := baseLockout << uint(attempts.failures-maxFailedLogins)
+		if lockout > maxLockout || lockout <= 0 {
+			lockout = maxLockout
+		}
+		attempts.lockedUntil = now.Add(lockout)
+	}
+}
+
+//Forget the failed logins of an ip after a successful login
+func (limiter *loginLimiter) succeed(ip string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.release(ip)
+	//Other attempts of the ip may still be in flight
+	if attempts, ok := limiter.attempts[ip]; ok {
+		if attempts.inFlight == 0 {
+			delete(limiter.attempts, ip)
+		} else {
+			attempts.failures, attempts.lockedUntil = 0, time.Time{}
+		}
+	}
+}
+
+//Forget the ips that didn't fail for longer than the maximum lockout
+func (limiter *loginLimiter) pruneAttempts(now time.Time) {
+	for ip, attempts := range limiter.attempts {
+		if attempts.inFlight == 0 && now.Sub(attempts.lastFailure) > maxLockout && now.After(attempts.lockedUntil) {
+			delete(limiter.attempts, ip)
+		}
+	}
+}
+
+func (limiter *loginLimiter) pruneGlobalFailures(now time.Time) {
+	i := 0
+	for i < len(limiter.globalFailures) && now.Sub(limiter.globalFailures[i]) > globalWindow {
+		i++
+	}
+	limiter.globalFailures = limiter.globalFailures[i:]
+}
+
+//Get the ip of the client of a request
+//X-Forwarded-For is only used when the request comes from a proxy listed in TRUSTED_PROXIES (ips or CIDRs separated by commas),
+//the client is then the last address of the header that is not a trusted proxy
+func getClientIp(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if proxyIp := net.ParseIP(proxy); proxyIp != nil && proxyIp.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,38 +1,97 @@
 package api
 
 import (
+	"dockerci/src/api/middleware"
 	"dockerci/src/docker"
+	"dockerci/src/store"
 	"dockerci/src/utils"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
 )
 
 type AuthRequest struct {
+	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+//Container listed by the api, the secret webhook url is only given to principals allowed to deploy it
+type ContainerResponse struct {
+	docker.ContainerInfo
+	HookUrl string `json:",omitempty"`
+}
+
 func (s *Server) fetchHooks(res http.ResponseWriter, req *http.Request) {
-	var filteredContainers []docker.ContainerInfo
-	for _, container := range *s.containers {
-		hasName := true
-		for _, name := range container.Names {
-			if strings.TrimSpace(name) == "" {
-				hasName = false
-				break
+	principal := getPrincipal(req)
+	var filteredContainers []ContainerResponse
+	for _, container := range s.getContainers() {
+		if strings.TrimSpace(container.Name) != "" && strings.TrimSpace(container.Id) != "" && principal.Can(store.ActionStatus, container.Name, container.Group) {
+			response := ContainerResponse{ContainerInfo: container}
+			if principal.Can(store.ActionDeploy, container.Name, container.Group) {
+				if key, err := s.hookKeys.Ensure(container.Name); err == nil {
+					response.HookUrl = getHookUrl(container.Name, key)
+				}
 			}
-		}
-		if hasName && strings.TrimSpace(container.Id) != "" {
-			filteredContainers = append(filteredContainers, container)
+			filteredContainers = append(filteredContainers, response)
 		}
 	}
 	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(200)
 	res.Write(utils.ToJSON(filteredContainers))
 }
+func (s *Server) fetchJobs(res http.ResponseWriter, req *http.Request) {
+	principal := getPrincipal(req)
+	jobs := make([]*docker.Job, 0)
+	for _, job := range s.client.GetJobs() {
+		if s.can(principal, store.ActionStatus, job.Container) {
+			jobs = append(jobs, job)
+		}
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(jobs))
+}
+func (s *Server) fetchJob(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	job := s.client.GetJob(mux.Vars(req)["id"])
+	if job == nil || !s.can(getPrincipal(req), store.ActionStatus, job.Container) {
+		res.WriteHeader(404)
+		res.Write(utils.ToJSON(map[string]string{"error": "Job not found"}))
+		return
+	}
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(job))
+}
+func (s *Server) cancelJob(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(req)["id"]
+	if job := s.client.GetJob(id); job != nil && !s.can(getPrincipal(req), store.ActionRollback, job.Container) {
+		res.WriteHeader(404)
+		res.Write(utils.ToJSON(map[string]string{"error": "Job not found"}))
+		return
+	}
+	err := s.client.CancelJob(id)
+	switch {
+	case errors.Is(err, docker.ErrJobNotFound):
+		res.WriteHeader(404)
+		res.Write(utils.ToJSON(map[string]string{"error": "Job not found"}))
+	case errors.Is(err, docker.ErrJobFinished):
+		res.WriteHeader(409)
+		res.Write(utils.ToJSON(map[string]string{"error": "Job is already finished"}))
+	case errors.Is(err, docker.ErrJobCommitted):
+		res.WriteHeader(409)
+		res.Write(utils.ToJSON(map[string]string{"error": "Job can no longer be cancelled"}))
+	default:
+		res.WriteHeader(202)
+		res.Write(utils.ToJSON(map[string]string{"status": "cancelling"}))
+	}
+}
 func (s *Server) auth(res http.ResponseWriter, req *http.Request) {
 	var data AuthRequest
 	if err := utils.FromJSON(req.Body, &data); err != nil {
@@ -40,13 +99,31 @@ func (s *Server) auth(res http.ResponseWriter, req *http.Request) {
 		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
 		return
 	}
-	if data.Password != os.Getenv("PASSWORD") {
+	//The dashboard used to only send a password, it corresponds to the admin user
+	if data.Username == "" {
+		data.Username = "admin"
+	}
+	ip := getClientIp(req)
+	if ok, wait := s.loginLimiter.allow(ip); !ok {
+		log.Printf("Login of %s from %s refused: too many failed attempts", data.Username, ip)
+		s.audit.Record(store.AuditEntry{Type: "login", Username: data.Username, Ip: ip, Reason: "too many failed attempts"})
+		res.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		res.WriteHeader(429)
+		res.Write(utils.ToJSON(map[string]string{"error": "Too many failed attempts, try again later"}))
+		return
+	}
+	user, err := s.users.Authenticate(data.Username, data.Password)
+	if err != nil {
+		log.Printf("Failed login of %s from %s", data.Username, ip)
+		s.loginLimiter.fail(ip)
+		s.audit.Record(store.AuditEntry{Type: "login", Username: data.Username, Ip: ip, Reason: err.Error()})
 		res.WriteHeader(401)
-		res.Write(utils.ToJSON(map[string]string{"error": "Invalid password"}))
+		res.Write(utils.ToJSON(map[string]string{"error": "Invalid username or password"}))
 		return
 	}
-	token := jwt.New(jwt.SigningMethodHS256)
-	auth, err := token.SignedString([]byte(os.Getenv("PRIVATE_KEY")))
+	s.loginLimiter.succeed(ip)
+	s.audit.Record(store.AuditEntry{Type: "login", Username: user.Username, Ip: ip, Success: true})
+	auth, err := middleware.NewToken(user.Username, string(user.Role))
 	if err != nil {
 		log.Println(err)
 		res.WriteHeader(500)
@@ -56,3 +133,93 @@ func (s *Server) auth(res http.ResponseWriter, req *http.Request) {
 	res.WriteHeader(200)
 	res.Write(utils.ToJSON(map[string]string{"token": auth}))
 }
+
+//Issue a new token for an authenticated user so that the session can be extended before it expires
+func (s *Server) refreshAuth(res http.ResponseWriter, req *http.Request) {
+	user, ok := getPrincipal(req).(*store.User)
+	if !ok {
+		res.WriteHeader(403)
+		res.Write(utils.ToJSON(map[string]string{"error": "Only users can refresh their session"}))
+		return
+	}
+	auth, err := middleware.NewToken(user.Username, string(user.Role))
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(500)
+		res.Write(utils.ToJSON(map[string]string{"error": "Internal server error"}))
+		return
+	}
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(map[string]string{"token": auth}))
+}
+
+//Deploy a container on behalf of an authenticated user or token allowed to deploy it
+func (s *Server) deploy(res http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	if !s.can(getPrincipal(req), store.ActionDeploy, name) {
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(403)
+		res.Write(utils.ToJSON(map[string]string{"error": "Forbidden"}))
+		return
+	}
+	s.dispatchDeployment(res, req, name, nil)
+}
+
+//Get the principal loaded by the auth middleware
+func getPrincipal(req *http.Request) store.Principal {
+	principal, _ := req.Context().Value(principalKey).(store.Principal)
+	return principal
+}
+
+//Check if a principal can act on a container from its webhook name
+func (s *Server) can(principal store.Principal, action store.Action, name string) bool {
+	group := ""
+	if container, err := docker.FindContainer(s.getContainers(), name); err == nil {
+		group = container.Group
+	}
+	return principal.Can(action, name, group)
+}
+
+//Generate a new webhook key for a container, the former webhook url stops working
+func (s *Server) rotateHookKey(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+	if _, err := docker.FindContainer(s.getContainers(), name); err != nil || !s.can(getPrincipal(req), store.ActionDeploy, name) {
+		res.WriteHeader(404)
+		res.Write(utils.ToJSON(map[string]string{"error": "Container not found"}))
+		return
+	}
+	key, err := s.hookKeys.Rotate(name)
+	if err != nil {
+		log.Println("Error while rotating webhook key:", err)
+		res.WriteHeader(500)
+		res.Write(utils.ToJSON(map[string]string{"error": "Internal server error"}))
+		return
+	}
+	log.Printf("[%s] Webhook key rotated by %s", name, getPrincipal(req).Identity())
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(map[string]string{"HookUrl": getHookUrl(name, key)}))
+}
+
+func getHookUrl(name string, key string) string {
+	return os.Getenv("BASE_URL") + "/hooks/" + name + "/" + key
+}
+
+//Query the audit log of authentication attempts
+//It can be filtered with the username, ip, success, since (RFC 3339 date) and limit query parameters
+func (s *Server) fetchAudit(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	params := req.URL.Query()
+	query := store.AuditQuery{Username: params.Get("username"), Ip: params.Get("ip"), Limit: 100}
+	if success, err := strconv.ParseBool(params.Get("success")); err == nil {
+		query.Success = &success
+	}
+	if since, err := time.Parse(time.RFC3339, params.Get("since")); err == nil {
+		query.Since = since
+	}
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil {
+		query.Limit = limit
+	}
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(s.audit.Query(query)))
+}
@@ -1,39 +1,147 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"dockerci/src/api/middleware"
 	"dockerci/src/docker"
+	"dockerci/src/store"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 )
 
 type Server struct {
-	router     *mux.Router
-	port       string
-	containers *[]docker.ContainerInfo
+	router        *mux.Router
+	port          string
+	client        *docker.DockerClient
+	getContainers func() []docker.ContainerInfo
+	onRequest     RequestHandler
+	users         *store.UserStore
+	tokens        *store.TokenStore
+	hookKeys      *store.HookKeyStore
+	audit         *store.AuditStore
+	loginLimiter  *loginLimiter
 }
-type RequestHandler func(name string, c *websocket.Conn) (int, string)
+type RequestHandler func(name string, stream docker.EventStream) (*docker.Job, error)
 
-func New(containers *[]docker.ContainerInfo, onRequest RequestHandler) *Server {
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+func New(client *docker.DockerClient, getContainers func() []docker.ContainerInfo, hookKeys *store.HookKeyStore, onRequest RequestHandler) *Server {
 	port := os.Getenv("PORT")
 	router := mux.NewRouter()
-	server := &Server{router, port, containers}
+	server := &Server{
+		router:        router,
+		port:          port,
+		client:        client,
+		getContainers: getContainers,
+		onRequest:     onRequest,
+		users:         store.NewUserStore(),
+		tokens:        store.NewTokenStore(),
+		hookKeys:      hookKeys,
+		audit:         store.NewAuditStore(),
+		loginLimiter:  newLoginLimiter(),
+	}
 	router.Use(mux.CORSMethodMiddleware(router))
-	router.HandleFunc("/hooks/{name}", func(res http.ResponseWriter, req *http.Request) {
-		handleHook(res, req, onRequest)
-	}).Methods("GET")
+	router.HandleFunc("/hooks/{name}", server.handleHook).Methods("GET", "POST")
+	router.HandleFunc("/hooks/{name}/{key}", server.handleHook).Methods("GET", "POST")
 	apiGroup := router.PathPrefix("/api").Subrouter()
-	apiGroup.HandleFunc("/", server.fetchHooks).Methods("GET")
 	apiGroup.HandleFunc("/auth", server.auth).Methods("POST")
+	//Every other api route requires a valid token
+	authGroup := apiGroup.NewRoute().Subrouter()
+	authGroup.Use(server.authMiddleware)
+	authGroup.HandleFunc("/", server.fetchHooks).Methods("GET")
+	authGroup.HandleFunc("/auth/refresh", server.refreshAuth).Methods("POST")
+	authGroup.HandleFunc("/containers/{name}/deploy", server.deploy).Methods("POST")
+	authGroup.HandleFunc("/containers/{name}/hook/rotate", server.rotateHookKey).Methods("POST")
+	authGroup.HandleFunc("/jobs", server.fetchJobs).Methods("GET")
+	authGroup.HandleFunc("/jobs/{id}", server.fetchJob).Methods("GET")
+	authGroup.HandleFunc("/jobs/{id}", server.cancelJob).Methods("DELETE")
+	//User and token management is restricted to admins
+	adminGroup := authGroup.NewRoute().Subrouter()
+	adminGroup.Use(adminMiddleware)
+	adminGroup.HandleFunc("/users", server.fetchUsers).Methods("GET")
+	adminGroup.HandleFunc("/users", server.saveUser).Methods("POST")
+	adminGroup.HandleFunc("/users/{username}", server.deleteUser).Methods("DELETE")
+	adminGroup.HandleFunc("/tokens", server.fetchTokens).Methods("GET")
+	adminGroup.HandleFunc("/tokens", server.createToken).Methods("POST")
+	adminGroup.HandleFunc("/tokens/{id}", server.revokeToken).Methods("DELETE")
+	adminGroup.HandleFunc("/audit", server.fetchAudit).Methods("GET")
 
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./dist")))
 	return server
 }
 
+//Authenticate a request from an api token or a dashboard JWT
+//A deleted user is not authorized anymore even if its token is still valid
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.authenticate(r)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principal)))
+	})
+}
+
+//Get the principal of a request from the token of its Authorization header or of its token query parameter
+//Invalid tokens are recorded in the audit log
+func (s *Server) authenticate(r *http.Request) (store.Principal, error) {
+	principal, err := s.getRequestPrincipal(r)
+	if err != nil {
+		s.audit.Record(store.AuditEntry{Type: "token", Ip: getClientIp(r), Reason: err.Error()})
+	}
+	return principal, err
+}
+
+func (s *Server) getRequestPrincipal(r *http.Request) (store.Principal, error) {
+	token := getRequestToken(r)
+	if token == "" {
+		return nil, errors.New("authorization token is empty")
+	}
+	if store.IsApiToken(token) {
+		if apiToken := s.tokens.Authenticate(token); apiToken != nil {
+			return apiToken, nil
+		}
+		return nil, errors.New("invalid api token")
+	}
+	claims, err := middleware.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if user := s.users.Get(claims.Username); user != nil {
+		return user, nil
+	}
+	return nil, errors.New("user " + claims.Username + " doesn't exist anymore")
+}
+
+func getRequestToken(r *http.Request) string {
+	if auth := strings.TrimSpace(r.Header.Get("Authorization")); auth != "" {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer"))
+	}
+	return r.URL.Query().Get("token")
+}
+
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !getPrincipal(r).IsAdmin() {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) Serve() {
 	log.Printf("Listening for requests at http://localhost:%s/hooks/", s.port)
 	if err := http.ListenAndServe(":"+s.port, s.router); err != nil {
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"dockerci/src/docker"
+	"dockerci/src/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+var errStreamClosed = errors.New("stream closed")
+
+//Event stream followed by a client until the deployment is over
+type clientStream interface {
+	docker.EventStream
+	Closed() <-chan struct{}
+}
+
+//Send deployment events as websocket text messages
+type websocketStream struct {
+	conn      *websocket.Conn
+	mutex     sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebsocketStream(conn *websocket.Conn) *websocketStream {
+	return &websocketStream{conn: conn, closed: make(chan struct{})}
+}
+
+func (stream *websocketStream) Send(message docker.StreamMessage) error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	select {
+	case <-stream.closed:
+		return errStreamClosed
+	default:
+		return stream.conn.WriteMessage(websocket.TextMessage, utils.ToJSON(message))
+	}
+}
+
+func (stream *websocketStream) Close(reason string) {
+	stream.closeOnce.Do(func() {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		close(stream.closed)
+		stream.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(time.Second))
+	})
+}
+
+func (stream *websocketStream) Closed() <-chan struct{} {
+	return stream.closed
+}
+
+//Send deployment events as server-sent events, the event name is the stream event
+type sseStream struct {
+	w         http.ResponseWriter
+	flusher   http.Flusher
+	mutex     sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+//Write the event stream headers and return the stream
+func newSSEStream(w http.ResponseWriter) (*sseStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseStream{w: w, flusher: flusher, closed: make(chan struct{})}, nil
+}
+
+func (stream *sseStream) Send(message docker.StreamMessage) error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	select {
+	case <-stream.closed:
+		return errStreamClosed
+	default:
+	}
+	if _, err := fmt.Fprintf(stream.w, "event: %s\ndata: %s\n\n", message.Event, utils.ToJSON(message)); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+//Send the close reason as a close event if there is one
+func (stream *sseStream) Close(reason string) {
+	stream.closeOnce.Do(func() {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		close(stream.closed)
+		if reason != "" {
+			fmt.Fprintf(stream.w, "event: close\ndata: %s\n\n", reason)
+			stream.flusher.Flush()
+		}
+	})
+}
+
+func (stream *sseStream) Closed() <-chan struct{} {
+	return stream.closed
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"dockerci/src/store"
+	"dockerci/src/utils"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) fetchTokens(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(s.tokens.List()))
+}
+
+//Mint an api token, its value is only returned in this response
+func (s *Server) createToken(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	var data store.ApiToken
+	if err := utils.FromJSON(req.Body, &data); err != nil {
+		res.WriteHeader(400)
+		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
+		return
+	}
+	data.CreatedBy = getPrincipal(req).Identity()
+	value, token, err := s.tokens.Create(data)
+	if errors.Is(err, store.ErrInvalidToken) {
+		res.WriteHeader(400)
+		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
+		return
+	} else if err != nil {
+		log.Println("Error while creating api token:", err)
+		res.WriteHeader(500)
+		res.Write(utils.ToJSON(map[string]string{"error": "Internal server error"}))
+		return
+	}
+	log.Printf("Api token %s created by %s", token.Name, data.CreatedBy)
+	res.WriteHeader(201)
+	res.Write(utils.ToJSON(map[string]interface{}{"token": value, "infos": token}))
+}
+
+func (s *Server) revokeToken(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(req)["id"]
+	if err := s.tokens.Revoke(id); errors.Is(err, store.ErrTokenNotFound) {
+		res.WriteHeader(404)
+		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
+		return
+	} else if err != nil {
+		log.Println("Error while revoking api token:", err)
+		res.WriteHeader(500)
+		res.Write(utils.ToJSON(map[string]string{"error": "Internal server error"}))
+		return
+	}
+	log.Printf("Api token %s revoked by %s", id, getPrincipal(req).Identity())
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(map[string]string{"status": "revoked"}))
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"dockerci/src/store"
+	"dockerci/src/utils"
+
+	"github.com/gorilla/mux"
+)
+
+type UserRequest struct {
+	store.User
+	Password string `json:"password"`
+}
+
+func (s *Server) fetchUsers(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(s.users.List()))
+}
+
+//Create or update a user, the password can be omitted to keep the current one
+func (s *Server) saveUser(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	var data UserRequest
+	if err := utils.FromJSON(req.Body, &data); err != nil {
+		res.WriteHeader(400)
+		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
+		return
+	}
+	data.User.PasswordHash = ""
+	if err := s.users.Save(data.User, data.Password); errors.Is(err, store.ErrInvalidUser) {
+		res.WriteHeader(400)
+		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
+		return
+	} else if err != nil {
+		log.Println("Error while saving user:", err)
+		res.WriteHeader(500)
+		res.Write(utils.ToJSON(map[string]string{"error": "Internal server error"}))
+		return
+	}
+	log.Printf("User %s saved by %s", data.Username, getPrincipal(req).Identity())
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(map[string]string{"status": "saved"}))
+}
+
+func (s *Server) deleteUser(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	username := mux.Vars(req)["username"]
+	if err := s.users.Delete(username); errors.Is(err, store.ErrUserNotFound) {
+		res.WriteHeader(404)
+		res.Write(utils.ToJSON(map[string]string{"error": err.Error()}))
+		return
+	} else if err != nil {
+		log.Println("Error while deleting user:", err)
+		res.WriteHeader(500)
+		res.Write(utils.ToJSON(map[string]string{"error": "Internal server error"}))
+		return
+	}
+	log.Printf("User %s deleted by %s", username, getPrincipal(req).Identity())
+	res.WriteHeader(200)
+	res.Write(utils.ToJSON(map[string]string{"status": "deleted"}))
+}
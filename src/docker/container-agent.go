@@ -1,25 +1,30 @@
 package docker
 
 import (
-	"bufio"
 	"context"
+	"dockerci/src/registry"
 	"dockerci/src/utils"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/gorilla/websocket"
+	"github.com/docker/docker/pkg/jsonmessage"
 )
 
+const defaultGracePeriod = 10 * time.Second
+
 //Represent the container update process
 type ContainerAgent struct {
 	docker         *DockerClient
@@ -28,27 +33,41 @@ type ContainerAgent struct {
 	name           string
 	containerInfos types.ContainerJSON
 	imageInfos     types.ImageInspect
+	image          string //Image of the new container
 	ctx            context.Context
-	sock           *websocket.Conn
+	removed        bool //Whether the former container has been removed
+	stream         EventStream
+	job            *Job
+	cancel         context.CancelFunc
+	secrets        []string //Secrets resolved from the labels, redacted from logs and streams
 }
 
-func NewContainerAgent(docker *DockerClient, containerId string, name string, sock *websocket.Conn) *ContainerAgent {
-	ctx := context.Background()
-	containerInfos, err := docker.cli.ContainerInspect(ctx, containerId)
+//The container can be referenced by its id or its name
+func NewContainerAgent(docker *DockerClient, container string, name string, stream EventStream, job *Job) *ContainerAgent {
+	ctx, cancel := context.WithCancel(context.Background())
+	containerInfos, err := docker.cli.ContainerInspect(ctx, container)
 	imageInfos, _, err1 := docker.cli.ImageInspectWithRaw(ctx, containerInfos.Image)
 	if err != nil || err1 != nil {
 		log.Println("Error while fetching container infos")
+		cancel()
 		return nil
 	}
+	//Agents without job only check for updates
+	if job != nil {
+		job.setCancel(cancel)
+	}
 	return &ContainerAgent{
 		docker:         docker,
-		containerId:    containerId,
+		containerId:    containerInfos.ID,
 		containerInfos: containerInfos,
 		imageInfos:     imageInfos,
+		image:          containerInfos.Config.Image,
 		name:           name,
 		ctx:            ctx,
 		cli:            docker.cli,
-		sock:           sock,
+		stream:         stream,
+		job:            job,
+		cancel:         cancel,
 	}
 }
 
@@ -61,14 +80,16 @@ func (agent *ContainerAgent) UpdateContainer() (err error) {
 			switch t := r.(type) {
 			case string:
 				err = errors.New(t)
-				agent.emit(Error, t)
 			case error:
 				err = t
-				agent.emit(Error, err.Error())
 			default:
 				err = errors.New("unknown panic")
 			}
+			err = errors.New(agent.redact(err.Error()))
 			agent.emit(Error, map[string]interface{}{"error": err.Error()})
+			if agent.job.IsCancelled() {
+				agent.emit(Cancelled, nil)
+			}
 		}
 	}()
 	if err != nil {
@@ -78,20 +99,12 @@ func (agent *ContainerAgent) UpdateContainer() (err error) {
 	if agent.isLocalImage() {
 		agent.print("Container is local image")
 		agent.emit(Build, nil)
-		context := agent.imageInfos.Config.Labels["dockerfile"]
-		if context == "" {
-			context = "."
-		}
-		dockerfile := agent.getLabel("dockerfile")
-		if dockerfile == "" {
-			dockerfile = "Dockerfile"
-		}
 		repo := agent.getLabel("repo")
-		status, err := agent.buildDockerImage(repo, dockerfile, agent.containerInfos.Config.Image, agent.getImageLabel("repo-sha"))
+		status, cacheHits, err := agent.buildDockerImage(repo, agent.containerInfos.Config.Image, agent.getImageLabel("repo-sha"))
 		if err != nil {
 			agent.panic("Error while building image", err)
 		}
-		agent.emit(BuildEnd, map[string]interface{}{"status": status})
+		agent.emit(BuildEnd, map[string]interface{}{"status": status, "cache_hits": cacheHits})
 		if !status {
 			return nil
 		}
@@ -99,9 +112,19 @@ func (agent *ContainerAgent) UpdateContainer() (err error) {
 		agent.print("Container is external image")
 		agent.emit(Pull, nil)
 		//Pulling Image
-		authToken := agent.getContainerCredsToken()
-		agent.print(agent.containerInfos.Config.Image)
-		status, err := agent.pullImage(agent.containerInfos.Config.Image, authToken, agent.imageInfos)
+		if agent.image, err = agent.getTargetImage(); err != nil {
+			agent.panic(err)
+		}
+		agent.job.setTag(getImageTag(agent.image))
+		authToken := agent.getContainerCredsToken(agent.image)
+		agent.print(agent.image)
+		imageInfos := agent.imageInfos
+		if !isSameImage(agent.image, agent.containerInfos.Config.Image) {
+			//The container moves to a new tag, it is deployed even if the image is the same
+			agent.print("New tag matching", agent.getLabel("semver"), "found:", agent.image)
+			imageInfos = types.ImageInspect{}
+		}
+		status, err := agent.pullImage(agent.image, authToken, imageInfos)
 		if err != nil {
 			agent.panic(err)
 		}
@@ -110,34 +133,60 @@ func (agent *ContainerAgent) UpdateContainer() (err error) {
 			return nil
 		}
 	}
+	agent.checkCancelled()
 
 	//Stopping Container
 	agent.emit(Stop, nil)
 	if agent.containerInfos.State.Running {
 		duration, _ := time.ParseDuration("5s")
 		if err = agent.cli.ContainerStop(agent.ctx, agent.containerId, &duration); err != nil {
+			agent.rollback("", err)
 			agent.panic("Error while stopping container:", err)
 		}
 	}
 	//Removing Container
 	agent.emit(Remove, nil)
-	agent.cli.ContainerRemove(agent.ctx, agent.containerId, types.ContainerRemoveOptions{
+	if err = agent.cli.ContainerRemove(agent.ctx, agent.containerId, types.ContainerRemoveOptions{
 		RemoveVolumes: false, RemoveLinks: false, Force: true,
-	})
+	}); err != nil {
+		agent.rollback("", err)
+		agent.panic("Error while removing container:", err)
+	}
+	agent.removed = true
 	//Recreating Container
 	agent.emit(Recreate, nil)
-	createdContainer, err := agent.cli.ContainerCreate(agent.ctx, agent.containerInfos.Config, agent.containerInfos.HostConfig, nil, nil, agent.containerInfos.Name)
+	createdId, err := agent.createContainer(agent.image)
 	if err != nil {
+		agent.rollback("", err)
 		agent.panic("Error while creating container:", err)
 	}
 	//Starting Container
-	agent.emit(Start, nil)
-	if err := agent.cli.ContainerStart(agent.ctx, createdContainer.ID, types.ContainerStartOptions{}); err != nil {
+	agent.emit(ContainerStart, nil)
+	if err := agent.cli.ContainerStart(agent.ctx, createdId, types.ContainerStartOptions{}); err != nil {
+		agent.rollback(createdId, err)
 		agent.panic("Error while starting container:", err)
 	}
-	//Removing former image
+	//Checking that the new container stays up and healthy before removing the former image
+	agent.emit(Check, nil)
+	if err := agent.checkContainer(createdId); err != nil {
+		agent.rollback(createdId, err)
+		agent.panic("Container check failed:", err)
+	}
+	//The new container is validated, the cleanup cannot be cancelled anymore
+	if !agent.job.commit() {
+		agent.rollback(createdId, ErrJobCancelled)
+		agent.panic(ErrJobCancelled)
+	}
+	agent.detachContext()
+	//Removing former image, unless the new container still uses it (a new tag or digest of the same image)
 	agent.emit(RemoveImage, nil)
-	if _, err := agent.cli.ImageRemove(agent.ctx, agent.imageInfos.ID, types.ImageRemoveOptions{Force: true}); err != nil {
+	createdInfos, err := agent.cli.ContainerInspect(agent.ctx, createdId)
+	if err != nil {
+		agent.panic("Error while inspecting new container:", err)
+	}
+	if createdInfos.Image == agent.imageInfos.ID {
+		agent.print("New container uses the former image, keeping it")
+	} else if _, err := agent.cli.ImageRemove(agent.ctx, agent.imageInfos.ID, types.ImageRemoveOptions{Force: true}); err != nil {
 		agent.panic("Error while removing former image:", err)
 	}
 	filterArgs := filters.NewArgs(filters.KeyValuePair{Key: "dangling", Value: "true"})
@@ -149,61 +198,249 @@ func (agent *ContainerAgent) UpdateContainer() (err error) {
 	return err
 }
 
+//Create a container of the given image from the saved container config and networks and return its id
+func (agent *ContainerAgent) createContainer(image string) (string, error) {
+	networkingConfig, additionalNetworks := agent.getNetworkingConfig()
+	config := *agent.containerInfos.Config
+	config.Image = image
+	createdContainer, err := agent.cli.ContainerCreate(agent.ctx, &config, agent.containerInfos.HostConfig, networkingConfig, nil, agent.containerInfos.Name)
+	if err != nil {
+		return "", err
+	}
+	if err := agent.connectNetworks(createdContainer.ID, additionalNetworks); err != nil {
+		return "", err
+	}
+	return createdContainer.ID, nil
+}
+
+//Wait for the grace period and check that the container is still running
+//If the image has a HEALTHCHECK the container must also become healthy, while it is still starting
+//at the end of the grace period the check goes on until its health is known or the health timeout is reached
+func (agent *ContainerAgent) checkContainer(containerId string) error {
+	gracePeriod := agent.getGracePeriod()
+	agent.print("Checking container during", gracePeriod.String())
+	deadline := time.Now().Add(gracePeriod)
+	var healthDeadline time.Time
+	for {
+		infos, err := agent.cli.ContainerInspect(agent.ctx, containerId)
+		if err != nil {
+			return err
+		}
+		if !infos.State.Running || infos.State.Restarting || infos.RestartCount > 0 {
+			return fmt.Errorf("container is not running (exit code %d)", infos.State.ExitCode)
+		}
+		health := infos.State.Health
+		if health != nil && health.Status == types.Unhealthy {
+			return errors.New("container is unhealthy")
+		}
+		if time.Now().After(deadline) {
+			if health == nil || health.Status == types.Healthy || health.Status == types.NoHealthcheck {
+				return nil
+			}
+			if healthDeadline.IsZero() {
+				healthTimeout := getHealthTimeout(infos.Config.Healthcheck)
+				agent.print("Container is still", health.Status, "waiting for its health check during", healthTimeout.String())
+				healthDeadline = time.Now().Add(healthTimeout)
+			} else if time.Now().After(healthDeadline) {
+				return fmt.Errorf("container is still %s after its health check timeout", health.Status)
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+//Get the longest time a container can take to become healthy or unhealthy from its health check config
+//The first check runs after the start period and one interval, the container is unhealthy after retries failed checks
+func getHealthTimeout(config *container.HealthConfig) time.Duration {
+	//Docker defaults of the HEALTHCHECK options
+	interval, timeout, startPeriod, retries := 30*time.Second, 30*time.Second, time.Duration(0), 3
+	if config != nil {
+		if config.Interval > 0 {
+			interval = config.Interval
+		}
+		if config.Timeout > 0 {
+			timeout = config.Timeout
+		}
+		if config.StartPeriod > 0 {
+			startPeriod = config.StartPeriod
+		}
+		if config.Retries > 0 {
+			retries = config.Retries
+		}
+	}
+	return startPeriod + time.Duration(retries)*(interval+timeout)
+}
+
+//Remove the failed container and recreate the former one from the saved config and image
+//The former image is tagged again so that the container config can be reused as is
+//If the former container has not been removed yet it is only started again
+func (agent *ContainerAgent) rollback(failedId string, cause error) {
+	//The deployment may have been cancelled, the restoration must not be
+	agent.detachContext()
+	agent.print("Rolling back container:", cause)
+	agent.emit(Rollback, map[string]interface{}{"reason": cause.Error()})
+	if !agent.removed {
+		if agent.containerInfos.State.Running {
+			if err := agent.cli.ContainerStart(agent.ctx, agent.containerId, types.ContainerStartOptions{}); err != nil {
+				agent.panic("Error while starting former container during rollback:", err)
+			}
+		}
+		agent.print("Former container restored")
+		return
+	}
+	if failedId != "" {
+		if err := agent.cli.ContainerRemove(agent.ctx, failedId, types.ContainerRemoveOptions{Force: true}); err != nil {
+			agent.panic("Error while removing failed container during rollback:", err)
+		}
+	}
+	if err := agent.cli.ImageTag(agent.ctx, agent.imageInfos.ID, agent.containerInfos.Config.Image); err != nil {
+		agent.panic("Error while tagging former image during rollback:", err)
+	}
+	formerId, err := agent.createContainer(agent.containerInfos.Config.Image)
+	if err != nil {
+		agent.panic("Error while recreating former container during rollback:", err)
+	}
+	if agent.containerInfos.State.Running {
+		if err := agent.cli.ContainerStart(agent.ctx, formerId, types.ContainerStartOptions{}); err != nil {
+			agent.panic("Error while starting former container during rollback:", err)
+		}
+	}
+	agent.print("Former container restored")
+}
+
+//Stop the deployment if it has been cancelled
+func (agent *ContainerAgent) checkCancelled() {
+	if agent.ctx.Err() != nil {
+		agent.panic("Deployment cancelled")
+	}
+}
+
+//Replace the deployment context with a context that cannot be cancelled
+func (agent *ContainerAgent) detachContext() {
+	agent.ctx = context.Background()
+}
+
 //Building Image from git repository
-func (agent *ContainerAgent) buildDockerImage(repoLink string, dockerfile string, image string, previousSha string) (status bool, err error) {
+//It returns the number of build steps that used the cache
+func (agent *ContainerAgent) buildDockerImage(repoLink string, image string, previousSha string) (status bool, cacheHits int, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = errors.New(r.(string))
 		}
 	}()
-	lastCommitSha, err := agent.getLastCommitSha(repoLink)
+	auth, err := agent.getGitAuth()
+	if err != nil {
+		panic(err.Error())
+	}
+	source := agent.getBuildSource(repoLink)
+	ref, lastCommitSha, err := agent.getLastCommitSha(source, auth)
 	if err != nil {
 		panic("Error while getting last commit sha: " + err.Error())
 	}
 	if previousSha == lastCommitSha {
 		agent.print("Image already up to date, stopping process...")
-		return false, nil
+		return false, 0, nil
 	}
-	reader, err := agent.cli.ImageBuild(agent.ctx, nil, types.ImageBuildOptions{
-		RemoteContext: repoLink,
-		Dockerfile:    dockerfile,
-		NoCache:       true,
-		ForceRemove:   true,
-		Remove:        true,
-		Tags:          []string{image},
-		Labels:        map[string]string{"docker-ci.repo-sha": lastCommitSha},
-	})
+	options, err := agent.getBuildOptions(image, lastCommitSha)
 	if err != nil {
-		agent.panic("while building image:", err)
+		panic(err.Error())
+	}
+	var buildContext io.Reader
+	//The docker daemon can only clone public repositories so private ones are cloned here
+	if auth != nil || isSshRemote(source.Url) {
+		if buildContext, err = agent.getGitContext(source, ref, auth); err != nil {
+			panic("Error while cloning repository: " + err.Error())
+		}
+	} else {
+		//The daemon builds the ref resolved here, otherwise it would default to master instead of the remote HEAD
+		//The url is kept as it is so that it still recognizes it as a git url
+		options.RemoteContext = strings.SplitN(repoLink, "#", 2)[0] + "#" + ref
+		if source.Dir != "" {
+			options.RemoteContext += ":" + source.Dir
+		}
 	}
-	scanner := bufio.NewScanner(reader.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		agent.emit(BuildMessage, line)
+	reader, err := agent.cli.ImageBuild(agent.ctx, buildContext, options)
+	if err != nil {
+		agent.panic("while building image:", err)
 	}
 	defer reader.Body.Close()
-	return true, err
+	//The build request succeeds even when the build fails, errors are only reported in the stream
+	decoder := json.NewDecoder(reader.Body)
+	imageId := ""
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			panic("Error while reading build stream: " + err.Error())
+		}
+		if msg.Error != nil {
+			panic("Error while building image: " + strings.TrimSpace(msg.Error.Message))
+		}
+		output := BuildOutput{Stream: msg.Stream, Status: msg.Status, Id: msg.ID}
+		if msg.Aux != nil {
+			var result types.BuildResult
+			if err := json.Unmarshal(*msg.Aux, &result); err == nil && result.ID != "" {
+				imageId, output.ImageId = result.ID, result.ID
+			}
+		}
+		if strings.Contains(msg.Stream, "Using cache") {
+			cacheHits++
+		}
+		agent.emit(BuildMessage, output)
+	}
+	if imageId == "" {
+		panic("Error while building image: no image was built")
+	}
+	agent.print("Built image", imageId)
+	if !options.NoCache {
+		agent.print("Build used the cache for", strconv.Itoa(cacheHits), "steps")
+	}
+	return true, cacheHits, nil
 }
 
 //Pull an image from a container registry with optional credentials
 //If the image already exists it returns false and
 //If the image is successfuly pulled it returns true
 func (agent *ContainerAgent) pullImage(image string, authToken string, imageInfos types.ImageInspect) (status bool, err error) {
+	//The remote digest is resolved first so that nothing is downloaded when the image didn't change
+	//If the registry can't be reached this way the digest is still checked while pulling
+	if digest, err := agent.getRemoteDigest(image); err != nil {
+		agent.print("Could not get remote image digest:", err)
+	} else if hasRepoDigest(imageInfos, image, digest) {
+		agent.print("Image already up to date, stopping process...")
+		return false, nil
+	} else {
+		agent.print("Pulling image with digest:", digest)
+	}
 	reader, err := agent.cli.ImagePull(agent.ctx, image, types.ImagePullOptions{All: false, RegistryAuth: authToken})
 	if err != nil {
 		return false, errors.New("Error while pulling image:" + err.Error())
 	}
-	scanner := bufio.NewScanner(reader)
+	defer reader.Close()
 	regex, err := regexp.Compile(`\b(sha256:[A-Fa-f0-9]{64})\b`)
 	if err != nil {
 		return false, errors.New("Error while compiling regex: " + err.Error())
 	}
 	//While pulling image we check if the image is new
 	//If not we stop the update process
-	for scanner.Scan() {
-		line := scanner.Text()
-		agent.emit(PullMessage, line)
-		if sha := regex.FindString(line); sha != "" {
+	decoder := json.NewDecoder(reader)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return false, errors.New("Error while reading pull stream: " + err.Error())
+		}
+		if msg.Error != nil {
+			return false, errors.New("Error while pulling image: " + msg.Error.Message)
+		}
+		progress := PullProgress{Id: msg.ID, Status: msg.Status}
+		if msg.Progress != nil {
+			progress.Current, progress.Total = msg.Progress.Current, msg.Progress.Total
+		}
+		agent.emit(PullMessage, progress)
+		if sha := regex.FindString(msg.Status); sha != "" {
 			agent.print("Pulling image with digest:", sha)
 			for _, digest := range imageInfos.RepoDigests {
 				//We get the digest from the repo digest (name@digest)
@@ -214,18 +451,69 @@ func (agent *ContainerAgent) pullImage(image string, authToken string, imageInfo
 			}
 		}
 	}
-	defer reader.Close()
-	return true, err
+	return true, nil
 }
 
 //Panic with container name
 func (agent *ContainerAgent) panic(args ...interface{}) {
-	log.Panicf("[%s] %v", agent.name, strings.Join(utils.InterfaceToStringSlice(args), " "))
+	log.Panicf("[%s] %v", agent.name, agent.redact(strings.Join(utils.InterfaceToStringSlice(args), " ")))
 }
 
 //Print with container name
 func (agent *ContainerAgent) print(args ...interface{}) {
-	log.Printf("[%s] %v", agent.name, strings.Join(utils.InterfaceToStringSlice(args), " "))
+	log.Printf("[%s] %v", agent.name, agent.redact(strings.Join(utils.InterfaceToStringSlice(args), " ")))
+}
+
+//Get the digest of an image in its registry
+func (agent *ContainerAgent) getRemoteDigest(image string) (string, error) {
+	return agent.getRegistryClient(image).GetDigest(agent.ctx, image)
+}
+
+//Check if the image of the container changed in its registry or in its git repository without deploying it
+//The remote version (image@digest or commit sha) is returned so that a version which failed to deploy can be recognized
+//The agent can't be used anymore after the check
+func (agent *ContainerAgent) HasUpdate() (updated bool, version string, err error) {
+	defer agent.cancel()
+	if !agent.isLocalImage() {
+		image, err := agent.getTargetImage()
+		if err != nil {
+			return false, "", err
+		}
+		digest, err := agent.getRemoteDigest(image)
+		if err != nil {
+			return false, "", err
+		}
+		if !isSameImage(image, agent.containerInfos.Config.Image) {
+			return true, image + "@" + digest, nil
+		}
+		return !hasRepoDigest(agent.imageInfos, image, digest), image + "@" + digest, nil
+	}
+	auth, err := agent.getGitAuth()
+	if err != nil {
+		return false, "", err
+	}
+	_, sha, err := agent.getLastCommitSha(agent.getBuildSource(agent.getLabel("repo")), auth)
+	if err != nil {
+		return false, "", err
+	}
+	return sha != agent.getImageLabel("repo-sha"), sha, nil
+}
+
+//Check if an image was pulled from the given digest of its repository
+func hasRepoDigest(imageInfos types.ImageInspect, image string, digest string) bool {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	for _, repoDigest := range imageInfos.RepoDigests {
+		//Repo digests are in the form name@digest
+		if repoNamed, err := reference.ParseNormalizedNamed(repoDigest); err == nil && repoNamed.Name() == named.Name() {
+			if digested, ok := repoNamed.(reference.Digested); ok && digested.Digest().String() == digest {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 //Determine if the container image is local or external from the label
@@ -234,69 +522,84 @@ func (agent *ContainerAgent) isLocalImage() bool {
 	return agent.getLabel("repo") != ""
 }
 
-//Read auth config from container labels and return a base64 encoded string for docker.
-func (agent *ContainerAgent) getContainerCredsToken() string {
-	serveraddress := agent.getLabel("auth-server")
-	password := agent.getLabel("password")
-	username := agent.getLabel("username")
-	if serveraddress != "" && username != "" && password != "" {
-		data, err := json.Marshal(DockerAuth{Username: username, Password: password, Serveraddress: serveraddress})
-		if err != nil {
-			agent.panic("Error while marshalling auth config:", err)
-		}
-		auth := base64.StdEncoding.EncodeToString(data)
-		return string(auth)
-	} else {
-		return ""
+//Get the registry credentials of an image
+//They are read from the mounted docker config file and its credential helpers, then from the container labels
+func (agent *ContainerAgent) getRegistryCredentials(image string) *registry.Credentials {
+	creds, err := registry.GetCredentials(image)
+	if err != nil {
+		agent.print("Error while reading docker config credentials:", err)
+	}
+	if creds != nil {
+		return creds
+	}
+	username, password := agent.getLabel("username"), agent.getLabel("password")
+	if username == "" || password == "" {
+		return nil
 	}
+	return &registry.Credentials{Username: username, Password: password, ServerAddress: agent.getLabel("auth-server")}
 }
 
-//Get the last commit sha from the git repository using git protocol
-//Regexs : https://regexr.com/6b5f6,
-func (agent *ContainerAgent) getLastCommitSha(remote string) (string, error) {
-	//We find the from the remote url branch name
-	branch := strings.Replace(regexp.MustCompile(`#[A-Za-z]+`).FindString(remote), "#", "", -1)
-	if branch == "" {
-		branch = "master"
-	}
-	//We get the last commit sha from the git protocol on the selected branch
-	remoteUrl := regexp.MustCompile(`(#\S+)|\.git`).ReplaceAllString(remote, "")
-	req, _ := http.NewRequest("GET", remoteUrl, nil)
-	req.Header.Set("User-Agent", "Docker-CI")
-	req.Header.Set("Content-Type", "application/x-git-upload-pack")
-	resp, err := http.Get(remoteUrl + ".git/info/refs?service=git-upload-pack")
-	if err != nil {
-		return "", err
+//Get the registry client of an image with its credentials
+func (agent *ContainerAgent) getRegistryClient(image string) *registry.Client {
+	return registry.NewClientFromCredentials(agent.getRegistryCredentials(image))
+}
+
+//Get the registry credentials of an image as a base64 encoded string for docker.
+func (agent *ContainerAgent) getContainerCredsToken(image string) string {
+	creds := agent.getRegistryCredentials(image)
+	if creds == nil {
+		return ""
 	}
-	body, err := ioutil.ReadAll(resp.Body)
-	sha := strings.Split(regexp.MustCompile(`[0-9a-f]{5,50} refs/heads/`+branch).FindString(string(body)), " ")[0]
+	data, err := json.Marshal(DockerAuth{Username: creds.Username, Password: creds.Password, IdentityToken: creds.IdentityToken, Serveraddress: creds.ServerAddress})
 	if err != nil {
-		return "", err
+		agent.panic("Error while marshalling auth config:", err)
 	}
-	return sha, nil
+	return base64.URLEncoding.EncodeToString(data)
 }
 
-//Emit a message to the current socket
+//Emit an event to the current stream and update the job phase
 func (agent *ContainerAgent) emit(event StreamEvent, data interface{}) {
-	var dataStruct []byte
-	if agent.sock != nil {
-		switch t := data.(type) {
-		case string:
-			dataStruct = []byte(t)
-		case nil:
-			break
-		case error:
-			dataStruct = []byte(t.Error())
-		default:
-			dataStruct = utils.ToJSON(t)
-		}
-		agent.sock.WriteMessage(websocket.TextMessage, dataStruct)
+	if event.IsPhase() {
+		agent.job.setPhase(event)
+	}
+	if agent.stream == nil {
+		return
+	}
+	if err, ok := data.(error); ok {
+		data = err.Error()
+	}
+	message := StreamMessage{
+		Version:   StreamProtocolVersion,
+		Event:     event,
+		Phase:     agent.job.currentPhase(),
+		Timestamp: time.Now(),
+		Container: agent.name,
+		Data:      agent.redactData(data),
 	}
+	agent.stream.Send(message)
+}
+
+//Get the duration during which a new container must stay up before the update is validated
+func (agent *ContainerAgent) getGracePeriod() time.Duration {
+	gracePeriod, err := time.ParseDuration(agent.getLabel("grace-period"))
+	if err != nil {
+		return defaultGracePeriod
+	}
+	return gracePeriod
 }
 
 //Get a docker-ci container label value
+//A value referencing a secret (file:/run/secrets/token or env:TOKEN) is resolved on each call
 func (agent *ContainerAgent) getLabel(key string) string {
-	return agent.containerInfos.Config.Labels["docker-ci."+key]
+	value, secret, err := ResolveLabel(agent.containerInfos.Config.Labels["docker-ci."+key])
+	if err != nil {
+		agent.print("Error while resolving secret of label", key+":", err)
+		return ""
+	}
+	if secret {
+		agent.addSecret(value)
+	}
+	return value
 }
 
 //Get a docker-ci image label value
@@ -0,0 +1,25 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestGetHealthTimeout(t *testing.T) {
+	tests := []struct {
+		config *container.HealthConfig
+		want   time.Duration
+	}{
+		{nil, 3 * time.Minute},
+		{&container.HealthConfig{}, 3 * time.Minute},
+		{&container.HealthConfig{Interval: 5 * time.Second, Timeout: 2 * time.Second, Retries: 2}, 14 * time.Second},
+		{&container.HealthConfig{Interval: 10 * time.Second, StartPeriod: time.Minute}, time.Minute + 3*40*time.Second},
+	}
+	for _, test := range tests {
+		if got := getHealthTimeout(test.config); got != test.want {
+			t.Errorf("getHealthTimeout(%+v) = %s, want %s", test.config, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+//Build cache modes of the build-cache label
+const (
+	//Every layer is rebuilt, it is the default mode
+	buildCacheOff = "off"
+	//The layers cached by the docker engine are reused
+	buildCacheLocal = "local"
+	//The layers of a previous image are reused
+	buildCacheFrom = "cache-from"
+)
+
+//Get the build options of the image from the container labels, like a compose build section
+func (agent *ContainerAgent) getBuildOptions(image string, sha string) (types.ImageBuildOptions, error) {
+	dockerfile := agent.getLabel("dockerfile")
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	options := types.ImageBuildOptions{
+		Dockerfile:  dockerfile,
+		ForceRemove: true,
+		Remove:      true,
+		Tags:        []string{image},
+		Target:      agent.getLabel("target"),
+		Platform:    agent.getLabel("platform"),
+		BuildArgs:   make(map[string]*string),
+		Labels:      agent.getLabelsWithPrefix("label."),
+	}
+	for key, value := range agent.getLabelsWithPrefix("build-arg.") {
+		value := value
+		options.BuildArgs[key] = &value
+	}
+	for _, tag := range strings.Split(agent.getLabel("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			options.Tags = append(options.Tags, tag)
+		}
+	}
+	options.Labels["docker-ci.repo-sha"] = sha
+	switch agent.getLabel("build-cache") {
+	case "", buildCacheOff:
+		options.NoCache = true
+	case buildCacheLocal:
+	case buildCacheFrom:
+		//The layers of the former image, or of the images of the cache-from label, are reused
+		options.CacheFrom = []string{image}
+		if cacheFrom := agent.getLabel("cache-from"); cacheFrom != "" {
+			options.CacheFrom = nil
+			for _, tag := range strings.Split(cacheFrom, ",") {
+				options.CacheFrom = append(options.CacheFrom, strings.TrimSpace(tag))
+			}
+		}
+	default:
+		return options, errors.New("Unknown build cache mode " + agent.getLabel("build-cache"))
+	}
+	return options, nil
+}
+
+//Get the build source with the context directory of the context label appended to the one of the url
+func (agent *ContainerAgent) getBuildSource(repo string) gitSource {
+	source := parseGitSource(repo)
+	if context := agent.getLabel("context"); context != "" {
+		source.Dir = strings.Trim(path.Join(source.Dir, context), "/")
+		if source.Dir == "." {
+			source.Dir = ""
+		}
+	}
+	return source
+}
+
+//Get the docker-ci labels starting with prefix, without the prefix
+func (agent *ContainerAgent) getLabelsWithPrefix(prefix string) map[string]string {
+	labels := make(map[string]string)
+	for key := range agent.containerInfos.Config.Labels {
+		if name := strings.TrimPrefix(key, "docker-ci."+prefix); name != key && name != "" {
+			labels[name] = agent.getLabel(prefix + name)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+//Build the networking config of the recreated container from the former network settings
+//The docker API only accepts the primary network at creation,
+//the other networks are returned so that they can be connected before the container starts
+func (agent *ContainerAgent) getNetworkingConfig() (*network.NetworkingConfig, map[string]*network.EndpointSettings) {
+	networkMode := agent.containerInfos.HostConfig.NetworkMode
+	primary := string(networkMode)
+	if networkMode.IsDefault() {
+		primary = "bridge"
+	}
+	networkingConfig := &network.NetworkingConfig{EndpointsConfig: make(map[string]*network.EndpointSettings)}
+	additionalNetworks := make(map[string]*network.EndpointSettings)
+	//Host, none and container network modes cannot have any endpoint config
+	if networkMode.IsHost() || networkMode.IsNone() || networkMode.IsContainer() || agent.containerInfos.NetworkSettings == nil {
+		return networkingConfig, additionalNetworks
+	}
+	for name, settings := range agent.containerInfos.NetworkSettings.Networks {
+		if settings == nil {
+			continue
+		}
+		endpoint := agent.getEndpointConfig(settings)
+		if name == primary {
+			networkingConfig.EndpointsConfig[name] = endpoint
+		} else {
+			additionalNetworks[name] = endpoint
+		}
+	}
+	return networkingConfig, additionalNetworks
+}
+
+//Copy the user defined part of an endpoint (aliases, links, static ips and driver options)
+//Runtime values like the endpoint id, the gateway or the dynamically assigned ips are dropped
+func (agent *ContainerAgent) getEndpointConfig(settings *network.EndpointSettings) *network.EndpointSettings {
+	aliases := make([]string, 0, len(settings.Aliases))
+	shortId := agent.containerId
+	if len(shortId) > 12 {
+		shortId = shortId[:12]
+	}
+	for _, alias := range settings.Aliases {
+		//Docker adds the short id of the container as an alias, the new container will get its own
+		if alias != shortId {
+			aliases = append(aliases, alias)
+		}
+	}
+	return &network.EndpointSettings{
+		IPAMConfig: settings.IPAMConfig,
+		Links:      settings.Links,
+		Aliases:    aliases,
+		DriverOpts: settings.DriverOpts,
+	}
+}
+
+//Connect a created container to its additional networks
+//If a network cannot be connected the created container is removed
+func (agent *ContainerAgent) connectNetworks(containerId string, networks map[string]*network.EndpointSettings) error {
+	for name, endpoint := range networks {
+		if err := agent.cli.NetworkConnect(agent.ctx, name, containerId, endpoint); err != nil {
+			agent.cli.ContainerRemove(agent.ctx, containerId, types.ContainerRemoveOptions{Force: true})
+			return err
+		}
+	}
+	return nil
+}
@@ -1,5 +1,10 @@
 package docker
 
+import (
+	"fmt"
+	"time"
+)
+
 type ContainerEvent string
 type ImageEvent string
 type StreamEvent int
@@ -43,12 +48,19 @@ const (
 // )
 
 type ContainerInfo struct {
-	Names []string
-	Id    string
+	Name            string
+	Group           string
+	Names           []string
+	Id              string
+	Image           string `json:"-"`
+	WebhookSecret   string `json:"-"`
+	WebhookCallback bool   `json:"-"`
+	PollInterval    string `json:"-"`
 }
 type DockerAuth struct {
 	Username      string `json:"username,omitempty"`
 	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
 	Serveraddress string `json:"serveraddress,omitempty"`
 }
 
@@ -67,4 +79,83 @@ const (
 	RemoveImage  StreamEvent = iota
 	Remove       StreamEvent = iota
 	End          StreamEvent = iota
+	Check        StreamEvent = iota
+	Rollback     StreamEvent = iota
+	Cancelled    StreamEvent = iota
+	//Start of the recreated container, Start is the start of the deployment
+	ContainerStart StreamEvent = iota
 )
+
+var streamEventNames = map[StreamEvent]string{
+	Start:          "start",
+	Pull:           "pull",
+	PullMessage:    "pull_message",
+	PullEnd:        "pull_end",
+	Build:          "build",
+	BuildMessage:   "build_message",
+	BuildEnd:       "build_end",
+	Stop:           "stop",
+	Recreate:       "recreate",
+	Restart:        "restart",
+	Error:          "error",
+	RemoveImage:    "remove_image",
+	Remove:         "remove",
+	End:            "end",
+	Check:          "check",
+	Rollback:       "rollback",
+	Cancelled:      "cancelled",
+	ContainerStart: "container_start",
+}
+
+func (event StreamEvent) String() string {
+	if name, ok := streamEventNames[event]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown_%d", int(event))
+}
+
+func (event StreamEvent) MarshalText() ([]byte, error) {
+	return []byte(event.String()), nil
+}
+
+//Message events and errors are emitted during a phase, they don't start a new one
+func (event StreamEvent) IsPhase() bool {
+	return event != PullMessage && event != BuildMessage && event != Error
+}
+
+//Version of the stream protocol, it must be incremented whenever the envelope changes
+const StreamProtocolVersion = 1
+
+//Envelope of every event sent to a deployment stream
+type StreamMessage struct {
+	Version   int         `json:"version"`
+	Event     StreamEvent `json:"event"`
+	Phase     StreamEvent `json:"phase"`
+	Timestamp time.Time   `json:"timestamp"`
+	Container string      `json:"container"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+//Destination of the events of a deployment (websocket, server-sent events...)
+//Implementations must be safe for concurrent use and ignore messages sent after Close
+type EventStream interface {
+	Send(message StreamMessage) error
+	Close(reason string)
+}
+
+//Output of an image build parsed from the docker JSON stream
+//ImageId is only set by the last message, once the image is built
+type BuildOutput struct {
+	Stream  string `json:"stream,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Id      string `json:"id,omitempty"`
+	ImageId string `json:"image_id,omitempty"`
+}
+
+//Progress of an image pull parsed from the docker JSON stream
+type PullProgress struct {
+	Id      string `json:"id,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+}
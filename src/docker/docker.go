@@ -2,20 +2,42 @@ package docker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"time"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrContainerNotFound = errors.New("container not found")
+	ErrDuplicateName     = errors.New("several containers use the same webhook name")
 )
 
 type DockerClient struct {
-	cli             *client.Client
-	Events          map[ContainerEvent]func(event events.Message) //Map with container event in key and function in value
-	containerAgents []*ContainerAgent
+	cli         *client.Client
+	Events      map[ContainerEvent]func(event events.Message) //Map with container event in key and function in value
+	jobs        []*Job                                        //Registry of the running and recent deployment jobs, most recent first
+	jobsMutex   sync.RWMutex
+	queues      map[string]*deploymentQueue //Map with the webhook name in key and its deployment queue in value
+	queuesMutex sync.Mutex
+	slots       chan struct{} //Limit the number of deployments running at once, nil if unlimited
+}
+
+//Deployments of a container are serialized: one running job and at most one pending follow-up
+type deploymentQueue struct {
+	running *Job
+	pending *Job
+	//Container reference and event stream of the pending job
+	container string
+	stream    EventStream
 }
 
 func New() *DockerClient {
@@ -28,7 +50,17 @@ func New() *DockerClient {
 		log.Fatal("Docker instance error:", err)
 	}
 	log.Println("Connected to docker sock version:", version.Version)
-	return &DockerClient{cli, make(map[ContainerEvent]func(event events.Message)), make([]*ContainerAgent, 0)}
+	var slots chan struct{}
+	if limit, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_DEPLOYMENTS")); err == nil && limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	return &DockerClient{
+		cli:    cli,
+		Events: make(map[ContainerEvent]func(event events.Message)),
+		jobs:   make([]*Job, 0),
+		queues: make(map[string]*deploymentQueue),
+		slots:  slots,
+	}
 }
 
 //Listen to container events and call the function associated with the event
@@ -75,14 +107,200 @@ func (docker *DockerClient) GetContainersEnabled() []types.Container {
 	return enabledContainers
 }
 
-// Create a new request and build a new container agent that will handle update
-func (docker *DockerClient) NewRequest(containerId string, name string, sock *websocket.Conn) error {
-	containerAgent := NewContainerAgent(docker, containerId, name, sock)
-	err := containerAgent.UpdateContainer()
-	if containerAgent.sock != nil {
-		containerAgent.sock.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(time.Second))
+//Build the ContainerInfo of an enabled container from its labels
+//The webhook name is taken from the docker-ci.name label or defaults to the container name
+func NewContainerInfo(container types.Container) ContainerInfo {
+	name := container.Labels["docker-ci.name"]
+	if name == "" && len(container.Names) > 0 {
+		name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	return ContainerInfo{
+		Name:            name,
+		Group:           container.Labels["docker-ci.group"],
+		Names:           container.Names,
+		Id:              container.ID,
+		Image:           container.Image,
+		WebhookSecret:   container.Labels["docker-ci.webhook-secret"],
+		WebhookCallback: container.Labels["docker-ci.webhook-callback"] == "true",
+		PollInterval:    container.Labels["docker-ci.poll-interval"],
+	}
+}
+
+//Get a ContainerInfo object from its webhook name
+//If several containers share the same webhook name an error is returned
+func FindContainer(containers []ContainerInfo, name string) (*ContainerInfo, error) {
+	var found *ContainerInfo
+	for i, container := range containers {
+		if strings.EqualFold(container.Name, name) {
+			if found != nil {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateName, name)
+			}
+			found = &containers[i]
+		}
+	}
+	if found == nil {
+		return nil, ErrContainerNotFound
+	}
+	return found, nil
+}
+
+//Get the webhook names that are used by several containers
+func DuplicateNames(containers []ContainerInfo) []string {
+	counts := make(map[string]int)
+	duplicates := make([]string, 0)
+	for _, container := range containers {
+		name := strings.ToLower(container.Name)
+		counts[name]++
+		if counts[name] == 2 {
+			duplicates = append(duplicates, container.Name)
+		}
+	}
+	return duplicates
+}
+
+// Create a new deployment job and run a container agent that will handle update in background
+// The container can be referenced by its id or its name, the job is returned immediately
+// If a deployment is already running for this container the request is collapsed into a single pending job
+// The stream is closed once the deployment is over
+func (docker *DockerClient) NewRequest(container string, name string, stream EventStream) (*Job, error) {
+	docker.queuesMutex.Lock()
+	defer docker.queuesMutex.Unlock()
+	queue, ok := docker.queues[name]
+	if !ok {
+		job := newJob(name)
+		docker.queues[name] = &deploymentQueue{running: job}
+		docker.addJob(job)
+		go docker.runJob(job, container, stream)
+		return job, nil
+	}
+	if queue.pending == nil {
+		queue.pending = newJob(name)
+		queue.container = container
+		queue.stream = stream
+		docker.addJob(queue.pending)
+		log.Printf("[%s] Deployment %s already running, request queued as job %s", name, queue.running.Id, queue.pending.Id)
+	} else {
+		if queue.stream == nil {
+			queue.stream = stream
+		} else if stream != nil {
+			stream.Close("Request collapsed into job " + queue.pending.Id)
+		}
+		log.Printf("[%s] Request collapsed into pending job %s", name, queue.pending.Id)
+	}
+	return queue.pending, nil
+}
+
+//Check if a deployment is running or pending for a webhook name
+func (docker *DockerClient) IsDeploying(name string) bool {
+	docker.queuesMutex.Lock()
+	defer docker.queuesMutex.Unlock()
+	_, ok := docker.queues[name]
+	return ok
+}
+
+func (docker *DockerClient) runJob(job *Job, container string, stream EventStream) {
+	if docker.slots != nil {
+		docker.slots <- struct{}{}
+	}
+	job.start()
+	var err error
+	if job.IsCancelled() {
+		err = ErrJobCancelled
+	} else if containerAgent := NewContainerAgent(docker, container, job.Container, stream, job); containerAgent != nil {
+		err = containerAgent.UpdateContainer()
+	} else {
+		err = errors.New("error while fetching container infos")
+	}
+	if stream != nil {
+		stream.Close("")
+	}
+	if err != nil {
+		log.Println("Error updating container "+job.Container, err)
+	} else {
+		log.Printf("Container %s successfully updated", job.Container)
+	}
+	job.finish(err)
+	if docker.slots != nil {
+		<-docker.slots
+	}
+	docker.runNextJob(job.Container)
+}
+
+//Start the pending job of a container once the running one is over
+func (docker *DockerClient) runNextJob(name string) {
+	docker.queuesMutex.Lock()
+	defer docker.queuesMutex.Unlock()
+	queue := docker.queues[name]
+	if queue.pending == nil {
+		delete(docker.queues, name)
+		return
+	}
+	job, container, stream := queue.pending, queue.container, queue.stream
+	docker.queues[name] = &deploymentQueue{running: job}
+	go docker.runJob(job, container, stream)
+}
+
+//Cancel a running or pending job
+//A pending job is removed from its queue, a running one is stopped and the former container is restored
+func (docker *DockerClient) CancelJob(id string) error {
+	job := docker.GetJob(id)
+	if job == nil {
+		return ErrJobNotFound
+	}
+	docker.queuesMutex.Lock()
+	if queue, ok := docker.queues[job.Container]; ok && queue.pending == job {
+		if queue.stream != nil {
+			queue.stream.Close("Deployment cancelled")
+		}
+		queue.pending, queue.stream = nil, nil
+		docker.queuesMutex.Unlock()
+		job.Cancel()
+		job.finish(ErrJobCancelled)
+		log.Printf("[%s] Pending job %s cancelled", job.Container, job.Id)
+		return nil
+	}
+	docker.queuesMutex.Unlock()
+	if err := job.Cancel(); err != nil {
+		return err
+	}
+	log.Printf("[%s] Cancelling job %s", job.Container, job.Id)
+	return nil
+}
+
+//Add a job to the registry and drop the oldest finished jobs
+func (docker *DockerClient) addJob(job *Job) {
+	docker.jobsMutex.Lock()
+	defer docker.jobsMutex.Unlock()
+	jobs := []*Job{job}
+	finished := 0
+	for _, j := range docker.jobs {
+		if j.IsFinished() {
+			if finished++; finished > maxJobHistory {
+				continue
+			}
+		}
+		jobs = append(jobs, j)
+	}
+	docker.jobs = jobs
+}
+
+//Get the running and recent jobs, most recent first
+func (docker *DockerClient) GetJobs() []*Job {
+	docker.jobsMutex.RLock()
+	defer docker.jobsMutex.RUnlock()
+	return append([]*Job{}, docker.jobs...)
+}
+
+//Get a job from its id
+func (docker *DockerClient) GetJob(id string) *Job {
+	docker.jobsMutex.RLock()
+	defer docker.jobsMutex.RUnlock()
+	for _, job := range docker.jobs {
+		if job.Id == id {
+			return job
+		}
 	}
-	return err
+	return nil
 }
 
 //Get the list of the listened events
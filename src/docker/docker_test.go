@@ -0,0 +1,50 @@
+package docker
+
+import "testing"
+
+//Build a client with a running job and a pending follow-up for the app container, without docker daemon
+func newQueuedClient() (*DockerClient, *Job, *Job) {
+	docker := &DockerClient{jobs: make([]*Job, 0), queues: make(map[string]*deploymentQueue)}
+	running, pending := newJob("app"), newJob("app")
+	running.start()
+	docker.queues["app"] = &deploymentQueue{running: running, pending: pending, container: "app"}
+	docker.addJob(running)
+	docker.addJob(pending)
+	return docker, running, pending
+}
+
+func TestCancelPendingJob(t *testing.T) {
+	docker, running, pending := newQueuedClient()
+	if err := docker.CancelJob(pending.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !pending.IsFinished() {
+		t.Fatal("cancelled pending job should be finished")
+	}
+	if status, message := pending.Result(); status != JobCancelled || message != ErrJobCancelled.Error() {
+		t.Errorf("pending job result = %s %q, want %s %q", status, message, JobCancelled, ErrJobCancelled.Error())
+	}
+	if queue := docker.queues["app"]; queue.pending != nil || queue.running != running {
+		t.Errorf("queue = %+v, want only the running job", queue)
+	}
+	if running.IsCancelled() || running.IsFinished() {
+		t.Error("running job should not be affected by the cancellation of the pending job")
+	}
+	if err := docker.CancelJob(pending.Id); err != ErrJobFinished {
+		t.Errorf("second CancelJob() error = %v, want %v", err, ErrJobFinished)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	docker, running, _ := newQueuedClient()
+	if err := docker.CancelJob("unknown"); err != ErrJobNotFound {
+		t.Errorf("CancelJob(unknown) error = %v, want %v", err, ErrJobNotFound)
+	}
+	if err := docker.CancelJob(running.Id); err != nil {
+		t.Fatal(err)
+	}
+	running.finish(ErrJobCancelled)
+	if status, _ := running.Result(); status != JobCancelled {
+		t.Errorf("running job status = %s, want %s", status, JobCancelled)
+	}
+}
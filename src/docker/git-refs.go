@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var shaRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+//A git build source as accepted by docker: url#ref:dir
+type gitSource struct {
+	Url string
+	//Branch, tag, full ref or commit sha, the remote HEAD if empty
+	Ref string
+	//Directory of the repository used as the build context
+	Dir string
+}
+
+//Refs advertised by a git remote
+type gitRefs struct {
+	//The branch targeted by the remote HEAD
+	Head string
+	//Commit sha of each ref, annotated tags are peeled to their commit
+	Refs map[string]string
+}
+
+//Parse a docker git url into its repository url, ref and context directory
+//Urls without scheme like github.com/user/repo are fetched through https
+func parseGitSource(remote string) gitSource {
+	source := gitSource{Url: remote}
+	if i := strings.Index(remote, "#"); i >= 0 {
+		source.Url, source.Ref = remote[:i], remote[i+1:]
+	}
+	if i := strings.Index(source.Ref, ":"); i >= 0 {
+		source.Ref, source.Dir = source.Ref[:i], source.Ref[i+1:]
+	}
+	source.Dir = strings.Trim(source.Dir, "/")
+	if !strings.Contains(source.Url, "://") && !isSshRemote(source.Url) {
+		source.Url = "https://" + source.Url
+	}
+	return source
+}
+
+//Resolve a ref the way git does: full sha, then branch, then tag, then full ref name
+//It returns the full name of the ref to fetch and its commit sha
+func (refs gitRefs) resolve(ref string) (name string, sha string, err error) {
+	if ref == "" {
+		if sha, ok := refs.Refs["HEAD"]; ok {
+			return "HEAD", sha, nil
+		}
+		return "", "", errors.New("Remote has no HEAD")
+	}
+	if shaRegex.MatchString(ref) {
+		return ref, ref, nil
+	}
+	for _, name := range []string{"refs/heads/" + ref, "refs/tags/" + ref, "refs/" + ref, ref} {
+		if sha, ok := refs.Refs[name]; ok {
+			return name, sha, nil
+		}
+	}
+	return "", "", errors.New("Ref " + ref + " not found")
+}
+
+//Add a ref, a peeled tag (name^{}) replaces the sha of the tag object by the sha of its commit
+func (refs *gitRefs) add(name string, sha string) {
+	if strings.HasSuffix(name, "^{}") {
+		refs.Refs[strings.TrimSuffix(name, "^{}")] = sha
+	} else if _, ok := refs.Refs[name]; !ok {
+		refs.Refs[name] = sha
+	}
+}
+
+//Read one pkt-line, flush packets are returned as nil
+func readPktLine(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pkt-line length %q", header)
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	if length < 4 {
+		return nil, fmt.Errorf("Invalid pkt-line length %d", length)
+	}
+	line := make([]byte, length-4)
+	if _, err := io.ReadFull(reader, line); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line, []byte("\n")), nil
+}
+
+//Parse the refs advertisement of the smart http protocol (info/refs?service=git-upload-pack)
+//The first ref carries the capabilities after a NUL byte, the HEAD symref is one of them
+func parseRefsAdvertisement(reader io.Reader) (gitRefs, error) {
+	refs := gitRefs{Refs: make(map[string]string)}
+	line, err := readPktLine(reader)
+	if err != nil {
+		return refs, err
+	}
+	//The service announcement is followed by a flush packet before the refs
+	if bytes.HasPrefix(line, []byte("# service=")) {
+		if line, err = readPktLine(reader); err != nil || line != nil {
+			return refs, errors.New("Invalid refs advertisement")
+		}
+		if line, err = readPktLine(reader); err != nil {
+			return refs, err
+		}
+	}
+	for first := true; line != nil; first = false {
+		if first {
+			var capabilities []byte
+			if i := bytes.IndexByte(line, 0); i >= 0 {
+				line, capabilities = line[:i], line[i+1:]
+			}
+			for _, capability := range strings.Fields(string(capabilities)) {
+				if strings.HasPrefix(capability, "symref=HEAD:") {
+					refs.Head = strings.TrimPrefix(capability, "symref=HEAD:")
+				}
+			}
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			return refs, fmt.Errorf("Invalid ref line %q", line)
+		}
+		//An empty repository only advertises its capabilities
+		if fields[1] != "capabilities^{}" {
+			refs.add(fields[1], fields[0])
+		}
+		if line, err = readPktLine(reader); err != nil {
+			return refs, err
+		}
+	}
+	return refs, nil
+}
+
+//Parse the output of git ls-remote --symref
+func parseLsRemote(reader io.Reader) (gitRefs, error) {
+	refs := gitRefs{Refs: make(map[string]string)}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "ref:" && fields[2] == "HEAD" {
+			refs.Head = fields[1]
+		} else if len(fields) == 2 {
+			refs.add(fields[1], fields[0])
+		}
+	}
+	return refs, scanner.Err()
+}
@@ -0,0 +1,186 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	mainSha    = "1111111111111111111111111111111111111111"
+	releaseSha = "2222222222222222222222222222222222222222"
+	tagSha     = "3333333333333333333333333333333333333333"
+	tagCommit  = "4444444444444444444444444444444444444444"
+)
+
+func TestParseGitSource(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   gitSource
+	}{
+		{"https://github.com/totodore/automate.git", gitSource{Url: "https://github.com/totodore/automate.git"}},
+		{"https://github.com/totodore/automate.git#release-1.2", gitSource{Url: "https://github.com/totodore/automate.git", Ref: "release-1.2"}},
+		{"https://github.com/totodore/automate.git#feature/x", gitSource{Url: "https://github.com/totodore/automate.git", Ref: "feature/x"}},
+		{"https://github.com/totodore/automate.git#main_v2", gitSource{Url: "https://github.com/totodore/automate.git", Ref: "main_v2"}},
+		{"https://github.com/totodore/automate.git#main:docker/app/", gitSource{Url: "https://github.com/totodore/automate.git", Ref: "main", Dir: "docker/app"}},
+		{"https://github.com/totodore/automate.git#:docker", gitSource{Url: "https://github.com/totodore/automate.git", Dir: "docker"}},
+		{"github.com/totodore/automate#v1.0.0", gitSource{Url: "https://github.com/totodore/automate", Ref: "v1.0.0"}},
+		{"git@github.com:totodore/automate.git#main:docker", gitSource{Url: "git@github.com:totodore/automate.git", Ref: "main", Dir: "docker"}},
+		{"ssh://git@github.com/totodore/automate.git#feature/x", gitSource{Url: "ssh://git@github.com/totodore/automate.git", Ref: "feature/x"}},
+	}
+	for _, test := range tests {
+		if got := parseGitSource(test.remote); got != test.want {
+			t.Errorf("parseGitSource(%q) = %+v, want %+v", test.remote, got, test.want)
+		}
+	}
+}
+
+func TestIsSshRemote(t *testing.T) {
+	tests := map[string]bool{
+		"git@github.com:totodore/automate.git":       true,
+		"ssh://git@github.com/totodore/automate.git": true,
+		"https://github.com/totodore/automate.git":   false,
+		"https://user@github.com/totodore/automate":  false,
+	}
+	for remote, want := range tests {
+		if got := isSshRemote(remote); got != want {
+			t.Errorf("isSshRemote(%q) = %v, want %v", remote, got, want)
+		}
+	}
+}
+
+//Encode lines as pkt-lines, an empty line is a flush packet
+func pktLines(lines ...string) string {
+	var builder strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			builder.WriteString("0000")
+		} else {
+			fmt.Fprintf(&builder, "%04x%s", len(line)+4, line)
+		}
+	}
+	return builder.String()
+}
+
+//Serve a refs advertisement like a smart http git server
+func newGitServer(advertisement string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repo.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.Write([]byte(advertisement))
+	}))
+}
+
+func TestGetGitRefs(t *testing.T) {
+	server := newGitServer(pktLines(
+		"# service=git-upload-pack\n",
+		"",
+		mainSha+" HEAD\x00multi_ack side-band-64k symref=HEAD:refs/heads/main agent=git/2.30\n",
+		mainSha+" refs/heads/main\n",
+		releaseSha+" refs/heads/release-1.2\n",
+		releaseSha+" refs/heads/feature/x\n",
+		releaseSha+" refs/pull/42/head\n",
+		tagSha+" refs/tags/v1.0.0\n",
+		tagCommit+" refs/tags/v1.0.0^{}\n",
+		"",
+	))
+	defer server.Close()
+	agent := &ContainerAgent{ctx: context.Background(), name: "test"}
+	refs, err := agent.getGitRefs(gitSource{Url: server.URL + "/repo.git"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs.Head != "refs/heads/main" {
+		t.Errorf("Head = %q, want refs/heads/main", refs.Head)
+	}
+	tests := []struct {
+		ref     string
+		name    string
+		sha     string
+		wantErr bool
+	}{
+		{"", "HEAD", mainSha, false},
+		{"main", "refs/heads/main", mainSha, false},
+		{"release-1.2", "refs/heads/release-1.2", releaseSha, false},
+		{"feature/x", "refs/heads/feature/x", releaseSha, false},
+		{"v1.0.0", "refs/tags/v1.0.0", tagCommit, false},
+		{"pull/42/head", "refs/pull/42/head", releaseSha, false},
+		{"refs/heads/main", "refs/heads/main", mainSha, false},
+		{tagSha, tagSha, tagSha, false},
+		{"master", "", "", true},
+	}
+	for _, test := range tests {
+		name, sha, err := refs.resolve(test.ref)
+		if (err != nil) != test.wantErr {
+			t.Errorf("resolve(%q) error = %v, want error %v", test.ref, err, test.wantErr)
+			continue
+		}
+		if name != test.name || sha != test.sha {
+			t.Errorf("resolve(%q) = %s %s, want %s %s", test.ref, name, sha, test.name, test.sha)
+		}
+	}
+	//Without ref the default branch of the remote is built
+	ref, sha, err := agent.getLastCommitSha(gitSource{Url: server.URL + "/repo.git"}, nil)
+	if err != nil || ref != "refs/heads/main" || sha != mainSha {
+		t.Errorf("getLastCommitSha() = %s %s %v, want refs/heads/main %s", ref, sha, err, mainSha)
+	}
+}
+
+func TestGetGitRefsEmptyRepository(t *testing.T) {
+	server := newGitServer(pktLines(
+		"# service=git-upload-pack\n",
+		"",
+		"0000000000000000000000000000000000000000 capabilities^{}\x00multi_ack agent=git/2.30\n",
+		"",
+	))
+	defer server.Close()
+	agent := &ContainerAgent{ctx: context.Background(), name: "test"}
+	refs, err := agent.getGitRefs(gitSource{Url: server.URL + "/repo.git"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs.Refs) != 0 || refs.Head != "" {
+		t.Errorf("refs = %+v, want no ref", refs)
+	}
+	if _, _, err := refs.resolve(""); err == nil {
+		t.Error("resolve of an empty repository should fail")
+	}
+}
+
+func TestParseRefsAdvertisementInvalid(t *testing.T) {
+	tests := []string{
+		"zzzz",
+		pktLines("# service=git-upload-pack\n", mainSha+" HEAD\n"),
+		pktLines(mainSha + "\n"),
+		"0010" + mainSha[:4],
+	}
+	for _, advertisement := range tests {
+		if _, err := parseRefsAdvertisement(strings.NewReader(advertisement)); err == nil {
+			t.Errorf("parseRefsAdvertisement(%q) should fail", advertisement)
+		}
+	}
+}
+
+func TestParseLsRemote(t *testing.T) {
+	output := "ref: refs/heads/main\tHEAD\n" +
+		mainSha + "\tHEAD\n" +
+		mainSha + "\trefs/heads/main\n" +
+		tagSha + "\trefs/tags/v1.0.0\n" +
+		tagCommit + "\trefs/tags/v1.0.0^{}\n"
+	refs, err := parseLsRemote(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs.Head != "refs/heads/main" {
+		t.Errorf("Head = %q, want refs/heads/main", refs.Head)
+	}
+	if _, sha, err := refs.resolve("v1.0.0"); err != nil || sha != tagCommit {
+		t.Errorf("resolve(v1.0.0) = %s %v, want %s", sha, err, tagCommit)
+	}
+}
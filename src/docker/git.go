@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"bytes"
+	"dockerci/src/store"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//Username sent with a git token when no git-username label is set, it is accepted by GitHub and GitLab
+const defaultGitUsername = "x-access-token"
+
+var scpRemoteRegex = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+//Credentials used to reach a private git repository
+type gitAuth struct {
+	username string
+	token    string
+	sshKey   string
+}
+
+//Read the git credentials from the container labels
+//The token can be given directly or through a file, like a docker secret, so it doesn't appear in docker inspect
+func (agent *ContainerAgent) getGitAuth() (*gitAuth, error) {
+	auth := &gitAuth{
+		username: agent.getLabel("git-username"),
+		token:    agent.getLabel("git-token"),
+		sshKey:   agent.getLabel("git-ssh-key"),
+	}
+	if file := agent.getLabel("git-token-file"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("Error while reading git token file: " + err.Error())
+		}
+		auth.token = strings.TrimSpace(string(data))
+		agent.addSecret(auth.token)
+	}
+	if auth.token == "" && auth.sshKey == "" {
+		return nil, nil
+	}
+	if auth.username == "" {
+		auth.username = defaultGitUsername
+	}
+	return auth, nil
+}
+
+//Check if a remote is reached through ssh (ssh://host/repo.git or git@host:repo.git)
+func isSshRemote(remote string) bool {
+	return strings.HasPrefix(remote, "ssh://") || scpRemoteRegex.MatchString(remote)
+}
+
+//Get the env of the git commands so that they authenticate with the given credentials without prompting
+//The token is passed as an http header through the env so it never appears in the command line
+func (auth *gitAuth) env() []string {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if auth == nil {
+		return env
+	}
+	if auth.token != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(auth.username + ":" + auth.token))
+		env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials)
+	}
+	if auth.sshKey != "" {
+		//Host keys are trusted on first use and kept in the conf directory
+		knownHosts := filepath.Join(store.Dir(), "known_hosts")
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s", shellQuote(auth.sshKey), shellQuote(knownHosts)))
+	}
+	return env
+}
+
+//Quote a value for the shell which runs GIT_SSH_COMMAND, single quotes are closed, escaped and reopened
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+//Run a git command with the given credentials and return its output
+func (agent *ContainerAgent) runGit(auth *gitAuth, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(agent.ctx, "git", args...)
+	cmd.Env = auth.env()
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %v %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+//Fetch the resolved ref of the repository in a temporary directory and return its context directory as a tar archive
+//It is used instead of a remote context when the docker daemon can't reach the repository by itself
+func (agent *ContainerAgent) getGitContext(source gitSource, ref string, auth *gitAuth) (io.Reader, error) {
+	tmp, err := ioutil.TempDir("", "docker-ci-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := agent.runGit(auth, "init", "--quiet", tmp); err != nil {
+		return nil, err
+	}
+	if _, err := agent.runGit(auth, "-C", tmp, "fetch", "--quiet", "--depth", "1", "--", source.Url, ref); err != nil {
+		return nil, err
+	}
+	treeish := "FETCH_HEAD"
+	if source.Dir != "" {
+		treeish += ":" + source.Dir
+	}
+	archive, err := agent.runGit(auth, "-C", tmp, "archive", "--format=tar", treeish)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(archive), nil
+}
+
+//List the refs of a remote repository
+//Through http they are read from the smart http advertisement, through ssh they are listed by git
+func (agent *ContainerAgent) getGitRefs(source gitSource, auth *gitAuth) (gitRefs, error) {
+	if isSshRemote(source.Url) {
+		out, err := agent.runGit(auth, "ls-remote", "--symref", "--", source.Url)
+		if err != nil {
+			return gitRefs{}, err
+		}
+		return parseLsRemote(bytes.NewReader(out))
+	}
+	req, err := http.NewRequestWithContext(agent.ctx, "GET", strings.TrimSuffix(source.Url, "/")+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return gitRefs{}, err
+	}
+	req.Header.Set("User-Agent", "Docker-CI")
+	if auth != nil && auth.token != "" {
+		req.SetBasicAuth(auth.username, auth.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return gitRefs{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return gitRefs{}, errors.New("Unexpected response from git server: " + resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-git-upload-pack-advertisement" {
+		return gitRefs{}, errors.New("Git server doesn't support smart http, got " + contentType)
+	}
+	return parseRefsAdvertisement(resp.Body)
+}
+
+//Get the ref to build and its last commit sha
+func (agent *ContainerAgent) getLastCommitSha(source gitSource, auth *gitAuth) (ref string, sha string, err error) {
+	refs, err := agent.getGitRefs(source, auth)
+	if err != nil {
+		return "", "", err
+	}
+	if source.Ref == "" && refs.Head != "" {
+		agent.print("Using remote default branch", refs.Head)
+		return refs.resolve(refs.Head)
+	}
+	return refs.resolve(source.Ref)
+}
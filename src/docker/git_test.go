@@ -0,0 +1,24 @@
+package docker
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []string{
+		"/run/secrets/deploy_key",
+		"/keys/it's key",
+		"/keys/'; touch /tmp/pwned; echo '",
+		`/keys/$HOME "key" \ ` + "`id`",
+	}
+	for _, value := range tests {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(value)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != value {
+			t.Errorf("shellQuote(%q) is read by the shell as %q", value, out)
+		}
+	}
+}
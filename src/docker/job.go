@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSuccess   JobStatus = "success"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job is already finished")
+	//Error of a job stopped by a cancellation, a cancelled job must be finished with it
+	ErrJobCancelled = errors.New("deployment cancelled")
+	//The new container is validated, the deployment can only go to its end
+	ErrJobCommitted = errors.New("job can no longer be cancelled")
+)
+
+//Maximum number of finished jobs kept in the registry
+const maxJobHistory = 50
+
+//Represent a deployment requested for a container
+type Job struct {
+	Id        string
+	Container string
+	Phase     StreamEvent
+	Status    JobStatus
+	QueuedAt  time.Time
+	StartedAt *time.Time
+	EndedAt   *time.Time
+	Error     string
+	//Tag of the deployed image, chosen with the semver label if it is set
+	Tag       string
+	mutex     sync.RWMutex
+	done      chan struct{}
+	cancelled bool
+	committed bool
+	cancel    context.CancelFunc
+}
+
+func newJob(container string) *Job {
+	return &Job{
+		Id:        newJobId(),
+		Container: container,
+		Phase:     Start,
+		Status:    JobQueued,
+		QueuedAt:  time.Now(),
+		done:      make(chan struct{}),
+	}
+}
+
+//Generate a random hexadecimal job id
+func newJobId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//Mark the job as running once a deployment slot is available
+func (job *Job) start() {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	now := time.Now()
+	job.StartedAt = &now
+	job.Status = JobRunning
+}
+
+//Update the current phase of the job
+func (job *Job) setPhase(phase StreamEvent) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.Phase = phase
+}
+
+//Record the tag of the deployed image
+func (job *Job) setTag(tag string) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.Tag = tag
+}
+
+//Get the current phase of the job
+func (job *Job) currentPhase() StreamEvent {
+	job.mutex.RLock()
+	defer job.mutex.RUnlock()
+	return job.Phase
+}
+
+//Mark the job as finished with the deployment result
+func (job *Job) finish(err error) {
+	job.mutex.Lock()
+	now := time.Now()
+	job.EndedAt = &now
+	//A deployment which ended without error is a success even if a cancellation was requested too late
+	if job.cancelled && err != nil {
+		job.Status = JobCancelled
+		job.Error = ErrJobCancelled.Error()
+	} else if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JobSuccess
+	}
+	job.mutex.Unlock()
+	close(job.done)
+}
+
+//Attach the cancel function of the deployment context
+//It is called right away if the job has already been cancelled
+func (job *Job) setCancel(cancel context.CancelFunc) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.cancel = cancel
+	if job.cancelled {
+		cancel()
+	}
+}
+
+//Mark the point of no return of the deployment, after it the job can't be cancelled
+//It returns false if the job was cancelled before
+func (job *Job) commit() bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if job.cancelled {
+		return false
+	}
+	job.committed = true
+	return true
+}
+
+//Request the cancellation of the job
+func (job *Job) Cancel() error {
+	if job.IsFinished() {
+		return ErrJobFinished
+	}
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if job.committed {
+		return ErrJobCommitted
+	}
+	job.cancelled = true
+	if job.cancel != nil {
+		job.cancel()
+	}
+	return nil
+}
+
+//Check if the cancellation of the job has been requested
+func (job *Job) IsCancelled() bool {
+	job.mutex.RLock()
+	defer job.mutex.RUnlock()
+	return job.cancelled
+}
+
+//Block until the job is finished
+func (job *Job) Wait() {
+	<-job.done
+}
+
+//Get a channel closed when the job is finished
+func (job *Job) Done() <-chan struct{} {
+	return job.done
+}
+
+//Check if the job is finished
+func (job *Job) IsFinished() bool {
+	select {
+	case <-job.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//Get the status and the error of the job
+func (job *Job) Result() (JobStatus, string) {
+	job.mutex.RLock()
+	defer job.mutex.RUnlock()
+	return job.Status, job.Error
+}
+
+//Marshal the job while holding its lock so that a running deployment can be reported safely
+func (job *Job) MarshalJSON() ([]byte, error) {
+	job.mutex.RLock()
+	defer job.mutex.RUnlock()
+	return json.Marshal(struct {
+		Id        string
+		Container string
+		Phase     StreamEvent
+		Status    JobStatus
+		QueuedAt  time.Time
+		StartedAt *time.Time
+		EndedAt   *time.Time
+		Error     string `json:",omitempty"`
+		Tag       string `json:",omitempty"`
+	}{job.Id, job.Container, job.Phase, job.Status, job.QueuedAt, job.StartedAt, job.EndedAt, job.Error, job.Tag})
+}
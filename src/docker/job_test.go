@@ -0,0 +1,35 @@
+package docker
+
+import "testing"
+
+func TestJobFinish(t *testing.T) {
+	tests := []struct {
+		name    string
+		cancel  bool
+		commit  bool
+		err     error
+		want    JobStatus
+		wantErr error
+	}{
+		{"success", false, false, nil, JobSuccess, nil},
+		{"failure", false, false, ErrContainerNotFound, JobFailed, nil},
+		{"cancelled", true, false, ErrJobCancelled, JobCancelled, nil},
+		{"cancelled after commit", false, true, nil, JobSuccess, ErrJobCommitted},
+	}
+	for _, test := range tests {
+		job := newJob("app")
+		job.start()
+		if test.commit && !job.commit() {
+			t.Fatalf("%s: commit() should succeed", test.name)
+		}
+		if test.cancel || test.commit {
+			if err := job.Cancel(); err != test.wantErr {
+				t.Errorf("%s: Cancel() error = %v, want %v", test.name, err, test.wantErr)
+			}
+		}
+		job.finish(test.err)
+		if status, _ := job.Result(); status != test.want {
+			t.Errorf("%s: status = %s, want %s", test.name, status, test.want)
+		}
+	}
+}
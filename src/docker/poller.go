@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Delay between two passes of the poller over the containers
+	pollTick = 5 * time.Second
+	//Part of the interval randomly added or removed so that checks are spread over time
+	pollJitter = 0.1
+	//Maximum interval when checks keep failing
+	maxPollBackoff = time.Hour
+)
+
+type pollState struct {
+	next     time.Time
+	failures int
+	checking bool
+	//Remote version whose deployment failed, it is not deployed again until the remote changes
+	failedVersion string
+}
+
+//Periodically check containers for new images or commits and trigger their deployment
+//It is used for registries and git hosts that can't send webhooks
+type Poller struct {
+	docker *DockerClient
+	//Interval of the containers without poll-interval label, polling is disabled if it is 0
+	interval      time.Duration
+	getContainers func() []ContainerInfo
+	onUpdate      func(container ContainerInfo) (*Job, error)
+	states        map[string]*pollState
+	mutex         sync.Mutex
+}
+
+//Create a poller with the default interval of the POLL_INTERVAL env var
+func NewPoller(docker *DockerClient, getContainers func() []ContainerInfo, onUpdate func(container ContainerInfo) (*Job, error)) *Poller {
+	interval, err := time.ParseDuration(os.Getenv("POLL_INTERVAL"))
+	if err != nil && os.Getenv("POLL_INTERVAL") != "" {
+		log.Println("Invalid POLL_INTERVAL, polling is only enabled with the poll-interval label:", err)
+	}
+	rand.Seed(time.Now().UnixNano())
+	return &Poller{
+		docker:        docker,
+		interval:      interval,
+		getContainers: getContainers,
+		onUpdate:      onUpdate,
+		states:        make(map[string]*pollState),
+	}
+}
+
+//Check the containers whose interval is elapsed, forever
+func (poller *Poller) Run() {
+	for range time.Tick(pollTick) {
+		now := time.Now()
+		names := make(map[string]bool)
+		for _, container := range poller.getContainers() {
+			names[container.Name] = true
+			interval := poller.getInterval(container)
+			if interval <= 0 {
+				continue
+			}
+			poller.mutex.Lock()
+			state, ok := poller.states[container.Name]
+			if !ok {
+				//The first check is delayed so that all the containers are not checked at once on startup
+				state = &pollState{next: now.Add(jitter(interval))}
+				poller.states[container.Name] = state
+			}
+			ready := !state.checking && now.After(state.next)
+			if ready {
+				state.checking = true
+			}
+			poller.mutex.Unlock()
+			if ready {
+				go poller.check(container, interval, state)
+			}
+		}
+		//Forget the containers that were removed
+		//A container being deployed by the poller is missing while it is recreated, its state is kept
+		poller.mutex.Lock()
+		for name, state := range poller.states {
+			if !names[name] && !state.checking {
+				delete(poller.states, name)
+			}
+		}
+		poller.mutex.Unlock()
+	}
+}
+
+//Check a container and deploy it if a new version is found
+//The deployment is awaited so that a failed version is not deployed, and rolled back, again at each interval
+func (poller *Poller) check(container ContainerInfo, interval time.Duration, state *pollState) {
+	var err error
+	updated, version := false, ""
+	//A deployment is already running or pending, it will get the last version
+	if !poller.docker.IsDeploying(container.Name) {
+		if agent := NewContainerAgent(poller.docker, strings.TrimPrefix(container.Names[0], "/"), container.Name, nil, nil); agent != nil {
+			updated, version, err = agent.HasUpdate()
+		} else {
+			err = ErrContainerNotFound
+		}
+	}
+	poller.mutex.Lock()
+	failedVersion := state.failedVersion
+	poller.mutex.Unlock()
+	if updated && version != failedVersion {
+		log.Printf("[%s] New version detected by polling", container.Name)
+		var job *Job
+		if job, err = poller.onUpdate(container); err == nil {
+			job.Wait()
+			if status, _ := job.Result(); status == JobFailed {
+				log.Printf("[%s] Deployment of %s failed, it won't be retried until a new version is available", container.Name, version)
+				failedVersion = version
+			}
+		}
+	}
+	poller.mutex.Lock()
+	defer poller.mutex.Unlock()
+	state.checking = false
+	state.failedVersion = failedVersion
+	if err != nil {
+		//Failed checks are retried less and less often so that a failing registry is not hammered
+		state.failures++
+		backoff := interval << state.failures
+		if backoff > maxPollBackoff || backoff <= 0 {
+			backoff = maxPollBackoff
+		}
+		if backoff < interval {
+			backoff = interval
+		}
+		state.next = time.Now().Add(jitter(backoff))
+		log.Printf("[%s] Poll check failed, next check in %s: %v", container.Name, backoff, err)
+		return
+	}
+	state.failures = 0
+	state.next = time.Now().Add(jitter(interval))
+}
+
+//Get the poll interval of a container, 0 if it is not polled
+func (poller *Poller) getInterval(container ContainerInfo) time.Duration {
+	if container.PollInterval == "" {
+		return poller.interval
+	}
+	if container.PollInterval == "off" {
+		return 0
+	}
+	interval, err := time.ParseDuration(container.PollInterval)
+	if err != nil {
+		return poller.interval
+	}
+	return interval
+}
+
+//Randomly shift a duration by up to pollJitter of its length
+func jitter(duration time.Duration) time.Duration {
+	return duration + time.Duration((rand.Float64()*2-1)*pollJitter*float64(duration))
+}
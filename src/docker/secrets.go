@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//Prefixes of the label values referencing a secret instead of containing it
+const (
+	fileSecretPrefix = "file:"
+	envSecretPrefix  = "env:"
+)
+
+//Replacement of the secret values in logs and streams
+const redactedSecret = "***"
+
+//Resolve a label value which can reference a secret: file:/run/secrets/token or env:TOKEN
+//Files are read from the docker-ci container, like mounted docker secrets
+func ResolveLabel(value string) (resolved string, secret bool, err error) {
+	if path := strings.TrimPrefix(value, fileSecretPrefix); path != value {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	if name := strings.TrimPrefix(value, envSecretPrefix); name != value {
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", true, errors.New("env var " + name + " is not set")
+		}
+		return resolved, true, nil
+	}
+	return value, false, nil
+}
+
+//Keep a resolved secret so that it is redacted from what the agent prints and sends
+func (agent *ContainerAgent) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	for _, known := range agent.secrets {
+		if known == secret {
+			return
+		}
+	}
+	agent.secrets = append(agent.secrets, secret)
+}
+
+//Replace the secrets resolved by the agent in a message
+func (agent *ContainerAgent) redact(message string) string {
+	for _, secret := range agent.secrets {
+		message = strings.ReplaceAll(message, secret, redactedSecret)
+	}
+	return message
+}
+
+//Replace the secrets resolved by the agent in the strings of a stream message data
+//The data is converted to its JSON representation so that nested values are redacted too
+func (agent *ContainerAgent) redactData(data interface{}) interface{} {
+	if len(agent.secrets) == 0 || data == nil {
+		return data
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return data
+	}
+	return agent.redactValue(value)
+}
+
+func (agent *ContainerAgent) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return agent.redact(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = agent.redactValue(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = agent.redactValue(v[key])
+		}
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"errors"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/docker/distribution/reference"
+)
+
+//Get the image to deploy
+//If the semver label is set, it is the image of the repository with the highest tag matching the constraint
+//otherwise it is the image of the container config
+func (agent *ContainerAgent) getTargetImage() (string, error) {
+	image := agent.containerInfos.Config.Image
+	label := agent.getLabel("semver")
+	if label == "" {
+		return image, nil
+	}
+	constraint, err := semver.NewConstraint(label)
+	if err != nil {
+		return "", errors.New("Invalid semver constraint " + label + ": " + err.Error())
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	tags, err := agent.getRegistryClient(image).GetTags(agent.ctx, image)
+	if err != nil {
+		return "", errors.New("Error while listing image tags: " + err.Error())
+	}
+	highestTag := getHighestTag(tags, constraint)
+	if highestTag == "" {
+		return "", errors.New("No tag matching " + label)
+	}
+	tagged, err := reference.WithTag(reference.TrimNamed(named), highestTag)
+	if err != nil {
+		return "", err
+	}
+	//The image is kept as written in the container config when its tag is already the highest
+	if isSameImage(tagged.String(), image) {
+		return image, nil
+	}
+	return reference.FamiliarString(tagged), nil
+}
+
+//Get the highest tag matching a semver constraint, or an empty string if there is none
+func getHighestTag(tags []string, constraint *semver.Constraints) string {
+	var highest *semver.Version
+	highestTag := ""
+	for _, tag := range tags {
+		//Tags which are not versions, like latest, are ignored
+		version, err := semver.NewVersion(tag)
+		if err != nil || !constraint.Check(version) {
+			continue
+		}
+		if highest == nil || version.GreaterThan(highest) {
+			highest, highestTag = version, tag
+		}
+	}
+	return highestTag
+}
+
+//Check if two image names reference the same image once normalized (nginx, docker.io/library/nginx:latest)
+func isSameImage(a string, b string) bool {
+	namedA, errA := reference.ParseNormalizedNamed(a)
+	namedB, errB := reference.ParseNormalizedNamed(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return reference.TagNameOnly(namedA).String() == reference.TagNameOnly(namedB).String()
+}
+
+//Get the tag of an image name, latest if it has none
+func getImageTag(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+	return ""
+}
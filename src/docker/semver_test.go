@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestGetHighestTag(t *testing.T) {
+	tags := []string{"latest", "1.0.0", "1.4.2", "1.4.10", "1.5.0-rc.1", "v1.5.1", "2.0.0", "2.1", "alpine", "3.0.0-beta"}
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"~1.4", "1.4.10"},
+		{"^1", "v1.5.1"},
+		{"~1.5", "v1.5.1"},
+		{"^2", "2.1"},
+		{">=3.0.0 <4", ""},
+		{"*", "2.1"},
+		{"<1", ""},
+	}
+	for _, test := range tests {
+		constraint, err := semver.NewConstraint(test.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := getHighestTag(tags, constraint); got != test.want {
+			t.Errorf("getHighestTag(%s) = %q, want %q", test.constraint, got, test.want)
+		}
+	}
+}
+
+func TestIsSameImage(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"nginx:1.21", "docker.io/library/nginx:1.21", true},
+		{"nginx", "docker.io/library/nginx:latest", true},
+		{"totodore/automate:v1", "index.docker.io/totodore/automate:v1", true},
+		{"totodore/automate:v1", "docker.io/totodore/automate:v1", true},
+		{"ghcr.io/totodore/automate:v1", "ghcr.io/totodore/automate:v1", true},
+		{"nginx:1.21", "nginx:1.22", false},
+		{"nginx:1.21", "ghcr.io/library/nginx:1.21", false},
+	}
+	for _, test := range tests {
+		if got := isSameImage(test.a, test.b); got != test.want {
+			t.Errorf("isSameImage(%s, %s) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
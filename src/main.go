@@ -4,17 +4,20 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 
 	"dockerci/src/api"
 	"dockerci/src/docker"
+	"dockerci/src/store"
 
 	"github.com/docker/docker/api/types/events"
-	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 )
 
 var client *docker.DockerClient
 var enabledContainers []docker.ContainerInfo
+var containersMutex sync.RWMutex
+var hookKeys *store.HookKeyStore
 
 //Parse the environment variables
 //Init docker instance and bind events
@@ -27,35 +30,63 @@ func main() {
 			log.Fatal("Error loading .env file")
 		}
 	}
+	hookKeys = store.NewHookKeyStore()
 	client = docker.New()
 	client.Events[docker.Create_container] = onCreateContainer
 	client.Events[docker.Destroy_container] = onDestroyContainer
 	go client.ListenToEvents()
 	loadContainersConfig()
-	api.New(&enabledContainers, onRequest).Serve()
+	go docker.NewPoller(client, getEnabledContainers, onPollUpdate).Run()
+	api.New(client, getEnabledContainers, hookKeys, onRequest).Serve()
 }
 
+//Get a copy of the enabled containers so that callers can't see or make concurrent changes
+func getEnabledContainers() []docker.ContainerInfo {
+	containersMutex.RLock()
+	defer containersMutex.RUnlock()
+	containers := make([]docker.ContainerInfo, len(enabledContainers))
+	copy(containers, enabledContainers)
+	return containers
+}
+
+//The list is built aside and swapped under the lock as it is reloaded from the docker events goroutine
 func loadContainersConfig() {
 	containers := client.GetContainersEnabled()
-	enabledContainers = make([]docker.ContainerInfo, len(containers))
+	loaded := make([]docker.ContainerInfo, 0, len(containers))
 	for _, container := range containers {
-		name := container.Names[0][1:]
-		enabledContainers = append(enabledContainers, docker.ContainerInfo{Names: container.Names, Id: container.ID})
-		log.Printf("Webhook available at: %s/hooks/%s", os.Getenv("BASE_URL"), name)
+		loaded = append(loaded, docker.NewContainerInfo(container))
+	}
+	containersMutex.Lock()
+	enabledContainers = loaded
+	containersMutex.Unlock()
+	for _, name := range docker.DuplicateNames(loaded) {
+		log.Printf("Several containers use the webhook name %s, rename them with the docker-ci.name label", name)
+	}
+	for _, container := range loaded {
+		//The webhook key is a secret, it is only given to authenticated users through the api
+		if _, err := hookKeys.Ensure(container.Name); err != nil {
+			log.Println("Error while generating webhook key for "+container.Name, err)
+		}
+		log.Printf("Webhook available at: %s/hooks/%s/<key>", os.Getenv("BASE_URL"), container.Name)
 	}
 }
-func onRequest(name string, sock *websocket.Conn) (int, string) {
-	containerInfos := getContainerFromName(name)
-	if containerInfos == nil {
-		return 400, "Container not found"
+func onRequest(name string, stream docker.EventStream) (*docker.Job, error) {
+	containerInfos, err := docker.FindContainer(getEnabledContainers(), name)
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
 	log.Println("Request received for service:", name)
-	if err := client.NewRequest(containerInfos.Id, name, sock); err != nil {
-		log.Println("Error updating container "+name, err)
-		return 500, "Failed to update container " + name
+	//The container is referenced by its name which doesn't change when it is recreated
+	//The webhook name is matched case insensitively, the canonical one keys the deployment queue so requests are serialized
+	return client.NewRequest(strings.TrimPrefix(containerInfos.Names[0], "/"), containerInfos.Name, stream)
+}
+func onPollUpdate(container docker.ContainerInfo) (*docker.Job, error) {
+	job, err := onRequest(container.Name, nil)
+	if err != nil {
+		log.Println("Error while requesting deployment of "+container.Name, err)
 	}
-	log.Printf("Container %s successfully updated", name)
-	return 200, "Done"
+	return job, err
 }
 func onCreateContainer(msg events.Message) {
 	if client.IsContainerEnabled(msg.Actor.ID) {
@@ -66,16 +97,3 @@ func onCreateContainer(msg events.Message) {
 func onDestroyContainer(msg events.Message) {
 	defer loadContainersConfig()
 }
-
-//Get a ContainerInfo object from a container name
-func getContainerFromName(name string) *docker.ContainerInfo {
-	name = strings.ToLower(name)
-	for _, container := range enabledContainers {
-		for _, containerName := range container.Names {
-			if strings.ToLower(containerName) == "/"+name {
-				return &container
-			}
-		}
-	}
-	return nil
-}
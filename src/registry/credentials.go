@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+//Key of docker hub in the docker config file and for credential helpers
+const dockerHubServer = "https://index.docker.io/v1/"
+
+//Username returned by credential helpers when the secret is an identity token
+const identityTokenUsername = "<token>"
+
+//Credentials of a registry
+type Credentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+	ServerAddress string
+}
+
+//Docker config file (~/.docker/config.json), only the registry auth part is read
+type configFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+//Get the path of the docker config file, in the DOCKER_CONFIG directory or in ~/.docker
+func getConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+//Get the registry host of an image, the docker hub server for docker hub images
+func GetServerAddress(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	if domain := reference.Domain(named); domain != dockerHubDomain {
+		return domain, nil
+	}
+	return dockerHubServer, nil
+}
+
+//Get the credentials of the registry of an image from the docker config file
+//Credential helpers are used first, then the auths entries. It returns nil if nothing is found
+func GetCredentials(image string) (*Credentials, error) {
+	server, err := GetServerAddress(image)
+	if err != nil {
+		return nil, err
+	}
+	path := getConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var config configFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.New("Invalid docker config file " + path + ": " + err.Error())
+	}
+	helper := config.CredsStore
+	if config.CredHelpers[server] != "" {
+		helper = config.CredHelpers[server]
+	}
+	if helper != "" {
+		creds, err := getHelperCredentials(helper, server)
+		if creds != nil || err != nil {
+			return creds, err
+		}
+	}
+	for key, auth := range config.Auths {
+		if normalizeServer(key) != normalizeServer(server) {
+			continue
+		}
+		creds := &Credentials{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken, ServerAddress: server}
+		//The auth field is the base64 of username:password
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, errors.New("Invalid auth of " + key + " in docker config file")
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.New("Invalid auth of " + key + " in docker config file")
+			}
+			creds.Username, creds.Password = parts[0], parts[1]
+		}
+		return creds, nil
+	}
+	return nil, nil
+}
+
+//Get credentials from a docker-credential-<helper> executable, it returns nil if the helper doesn't know the server
+func getHelperCredentials(helper string, server string) (*Credentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		//Helpers answer with this message when they have no credentials for the server
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, errors.New("Error while running credential helper " + helper + ": " + strings.TrimSpace(stdout.String()+stderr.String()))
+	}
+	var res struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, errors.New("Invalid response of credential helper " + helper + ": " + err.Error())
+	}
+	if res.Username == identityTokenUsername {
+		return &Credentials{IdentityToken: res.Secret, ServerAddress: server}, nil
+	}
+	return &Credentials{Username: res.Username, Password: res.Secret, ServerAddress: server}, nil
+}
+
+//Get the host of a docker config key, which can be an url like https://index.docker.io/v1/
+func normalizeServer(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server = strings.SplitN(server, "/", 2)[0]
+	if server == "index.docker.io" || server == dockerHubRegistry {
+		return dockerHubDomain
+	}
+	return server
+}
@@ -0,0 +1,292 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+//Manifest types accepted when resolving a tag, manifest lists are asked first
+//so that the digest is the one recorded by docker when pulling the tag
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+//Docker hub is aliased as docker.io in image names
+const dockerHubDomain = "docker.io"
+const dockerHubRegistry = "registry-1.docker.io"
+
+//Client of the registry v2 API
+type Client struct {
+	http     *http.Client
+	username string
+	password string
+	//Refresh token given by docker login with an identity provider, it replaces the username and password
+	identityToken string
+	//Bearer tokens by scope
+	tokens map[string]string
+	mutex  sync.Mutex
+}
+
+//Create a registry client, username and password are optional
+func NewClient(username string, password string) *Client {
+	return &Client{
+		http:     &http.Client{Timeout: 30 * time.Second},
+		username: username,
+		password: password,
+		tokens:   make(map[string]string),
+	}
+}
+
+//Create a registry client from credentials read in the docker config, creds can be nil for anonymous access
+func NewClientFromCredentials(creds *Credentials) *Client {
+	if creds == nil {
+		return NewClient("", "")
+	}
+	c := NewClient(creds.Username, creds.Password)
+	c.identityToken = creds.IdentityToken
+	return c
+}
+
+//Get the digest of the manifest, or of the manifest list, referenced by an image name
+func (c *Client) GetDigest(ctx context.Context, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	named = reference.TagNameOnly(named)
+	ref := ""
+	if digested, ok := named.(reference.Digested); ok {
+		return digested.Digest().String(), nil
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		ref = tagged.Tag()
+	}
+	res, err := c.do(ctx, "HEAD", named, "/manifests/"+ref, strings.Join(manifestTypes, ", "))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected response from registry: %s", res.Status)
+	}
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", errors.New("Registry didn't send the manifest digest")
+	}
+	return digest, nil
+}
+
+//List the tags of the repository of an image, following the pagination links
+func (c *Client) GetTags(ctx context.Context, image string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0)
+	path := "/tags/list"
+	for path != "" {
+		res, err := c.do(ctx, "GET", named, path, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Unexpected response from registry: %s", res.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, body.Tags...)
+		path = getNextPath(res.Header.Get("Link"), named)
+	}
+	return tags, nil
+}
+
+//Get the path of the next page from a Link header: </v2/name/tags/list?last=tag&n=100>; rel="next"
+func getNextPath(link string, named reference.Named) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	next, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimPrefix(next.Path, "/v2/"+reference.Path(named))
+	if next.RawQuery != "" {
+		path += "?" + next.RawQuery
+	}
+	return path
+}
+
+//Send a request to the repository of an image, authenticating if the registry asks for it
+func (c *Client) do(ctx context.Context, method string, named reference.Named, path string, accept string) (*http.Response, error) {
+	domain := reference.Domain(named)
+	if domain == dockerHubDomain {
+		domain = dockerHubRegistry
+	}
+	endpoint := "https://" + domain + "/v2/" + reference.Path(named) + path
+	scope := "repository:" + reference.Path(named) + ":pull"
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "Docker-CI")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return req, nil
+	}
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	token := c.tokens[scope]
+	c.mutex.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	//The registry asks for credentials through a Basic or a Bearer challenge
+	res.Body.Close()
+	scheme, params := parseChallenge(res.Header.Get("WWW-Authenticate"))
+	if req, err = newRequest(); err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return nil, errors.New("Registry requires credentials")
+		}
+		req.SetBasicAuth(c.username, c.password)
+	case "bearer":
+		if params["scope"] == "" {
+			params["scope"] = scope
+		}
+		token, err := c.getToken(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.Lock()
+		c.tokens[scope] = token
+		c.mutex.Unlock()
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return nil, errors.New("Unsupported registry auth challenge: " + scheme)
+	}
+	return c.http.Do(req)
+}
+
+//Get a bearer token from the realm of a challenge
+//With an identity token it is exchanged through the OAuth2 refresh token grant, otherwise the username and password are sent
+func (c *Client) getToken(ctx context.Context, params map[string]string) (string, error) {
+	if params["realm"] == "" {
+		return "", errors.New("Registry auth challenge has no realm")
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	var req *http.Request
+	var err error
+	if c.identityToken != "" {
+		query.Set("grant_type", "refresh_token")
+		query.Set("refresh_token", c.identityToken)
+		query.Set("client_id", "docker-ci")
+		req, err = http.NewRequestWithContext(ctx, "POST", params["realm"], strings.NewReader(query.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "GET", params["realm"]+"?"+query.Encode(), nil)
+		if err == nil && c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Docker-CI")
+	res, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected response from registry auth server: %s", res.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("Registry auth server didn't send a token")
+}
+
+//Parse a WWW-Authenticate header: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	header = strings.TrimSpace(header)
+	i := strings.Index(header, " ")
+	if i < 0 {
+		return strings.ToLower(header), params
+	}
+	scheme, rest := strings.ToLower(header[:i]), header[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key, value := strings.ToLower(strings.TrimSpace(rest[:eq])), ""
+		rest = rest[eq+1:]
+		if strings.HasPrefix(rest, `"`) {
+			//Quoted values can contain commas, like a scope with several actions
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
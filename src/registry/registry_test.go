@@ -0,0 +1,267 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+)
+
+const testDigest = "sha256:6a92cd1fcdc8d8cdec60f33dda4db2cb1fcdcacf3410a8e05b3741f44a9b5998"
+
+//Fake registry serving the manifest and the tags of totodore/automate
+//The auth mode is none, basic or bearer, the token server is served on /token
+func newRegistryServer(t *testing.T, auth string) (*httptest.Server, *[]string) {
+	var accepts []string
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			//Identity tokens are exchanged with the OAuth2 refresh token grant
+			if r.Method == "POST" {
+				if r.PostFormValue("grant_type") != "refresh_token" || r.PostFormValue("refresh_token") != "identity-token" ||
+					r.PostFormValue("service") != "registry.test" || r.PostFormValue("scope") != "repository:totodore/automate:pull" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"access_token": "registry-token"}`))
+				return
+			}
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "user" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("service") != "registry.test" || r.URL.Query().Get("scope") != "repository:totodore/automate:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token": "registry-token"}`))
+		case "/v2/totodore/automate/manifests/latest", "/v2/totodore/automate/manifests/nodigest", "/v2/totodore/automate/tags/list":
+			switch auth {
+			case "basic":
+				if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+					w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			case "bearer":
+				if r.Header.Get("Authorization") != "Bearer registry-token" {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry.test",scope="repository:totodore/automate:pull"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+			if strings.HasSuffix(r.URL.Path, "/tags/list") {
+				writeTagsPage(w, r)
+				return
+			}
+			if r.Method != "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			accepts = append(accepts, r.Header.Get("Accept"))
+			if !strings.HasSuffix(r.URL.Path, "/nodigest") {
+				w.Header().Set("Docker-Content-Digest", testDigest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &accepts
+}
+
+//Tags of totodore/automate, served two by two with Link headers like the docker hub
+var testTags = []string{"1.0.0", "1.1.0", "1.2.0-rc.1", "2.0.0", "latest"}
+
+func writeTagsPage(w http.ResponseWriter, r *http.Request) {
+	start := 0
+	if last := r.URL.Query().Get("last"); last != "" {
+		for i, tag := range testTags {
+			if tag == last {
+				start = i + 1
+			}
+		}
+	}
+	end := start + 2
+	if end < len(testTags) {
+		w.Header().Set("Link", `</v2/totodore/automate/tags/list?last=`+testTags[end-1]+`&n=2>; rel="next"`)
+	} else {
+		end = len(testTags)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": "totodore/automate", "tags": testTags[start:end]})
+}
+
+func newTestClient(server *httptest.Server, username string, password string) *Client {
+	c := NewClient(username, password)
+	c.http = server.Client()
+	return c
+}
+
+func TestGetDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     string
+		username string
+		password string
+		tag      string
+		wantErr  bool
+	}{
+		{"anonymous", "none", "", "", "latest", false},
+		{"basic challenge", "basic", "user", "secret", "latest", false},
+		{"basic challenge without credentials", "basic", "", "", "latest", true},
+		{"bearer challenge", "bearer", "user", "secret", "latest", false},
+		{"bearer challenge with bad credentials", "bearer", "user", "wrong", "latest", true},
+		{"missing digest", "none", "", "", "nodigest", true},
+		{"unknown repository", "none", "", "", "unknown", true},
+	}
+	for _, test := range tests {
+		server, accepts := newRegistryServer(t, test.auth)
+		c := newTestClient(server, test.username, test.password)
+		image := strings.TrimPrefix(server.URL, "https://") + "/totodore/automate:" + test.tag
+		digest, err := c.GetDigest(context.Background(), image)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: GetDigest() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if digest != testDigest {
+			t.Errorf("%s: GetDigest() = %s, want %s", test.name, digest, testDigest)
+		}
+		//The manifest list must be accepted so that the digest matches the one recorded by docker
+		if len(*accepts) != 1 || !strings.HasPrefix((*accepts)[0], "application/vnd.docker.distribution.manifest.list.v2+json") {
+			t.Errorf("%s: Accept = %v, want the manifest list type first", test.name, *accepts)
+		}
+	}
+}
+
+func TestGetDigestReusesToken(t *testing.T) {
+	server, accepts := newRegistryServer(t, "bearer")
+	c := newTestClient(server, "user", "secret")
+	image := strings.TrimPrefix(server.URL, "https://") + "/totodore/automate"
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetDigest(context.Background(), image); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*accepts) != 2 {
+		t.Errorf("got %d manifest requests, want 2", len(*accepts))
+	}
+	if c.tokens["repository:totodore/automate:pull"] != "registry-token" {
+		t.Errorf("tokens = %v, want the token cached by scope", c.tokens)
+	}
+}
+
+func TestGetDigestIdentityToken(t *testing.T) {
+	tests := []struct {
+		identityToken string
+		wantErr       bool
+	}{
+		{"identity-token", false},
+		{"expired-token", true},
+	}
+	for _, test := range tests {
+		server, _ := newRegistryServer(t, "bearer")
+		c := NewClientFromCredentials(&Credentials{IdentityToken: test.identityToken})
+		c.http = server.Client()
+		digest, err := c.GetDigest(context.Background(), strings.TrimPrefix(server.URL, "https://")+"/totodore/automate")
+		if (err != nil) != test.wantErr {
+			t.Errorf("GetDigest() with identity token %s error = %v, want error %v", test.identityToken, err, test.wantErr)
+		} else if err == nil && digest != testDigest {
+			t.Errorf("GetDigest() = %s, want %s", digest, testDigest)
+		}
+	}
+}
+
+func TestGetTags(t *testing.T) {
+	tests := []struct {
+		auth     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{"none", "", "", false},
+		{"basic", "user", "secret", false},
+		{"bearer", "user", "secret", false},
+		{"bearer", "", "", true},
+	}
+	for _, test := range tests {
+		server, _ := newRegistryServer(t, test.auth)
+		c := newTestClient(server, test.username, test.password)
+		tags, err := c.GetTags(context.Background(), strings.TrimPrefix(server.URL, "https://")+"/totodore/automate:1.0.0")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: GetTags() error = %v, want error %v", test.auth, err, test.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(tags, testTags) {
+			t.Errorf("%s: GetTags() = %v, want every page %v", test.auth, tags, testTags)
+		}
+	}
+}
+
+func TestGetNextPath(t *testing.T) {
+	named, _ := reference.ParseNormalizedNamed("ghcr.io/totodore/automate")
+	tests := map[string]string{
+		`</v2/totodore/automate/tags/list?last=1.1.0&n=2>; rel="next"`:               "/tags/list?last=1.1.0&n=2",
+		`<https://ghcr.io/v2/totodore/automate/tags/list?last=v2&n=100>; rel="next"`: "/tags/list?last=v2&n=100",
+		`</v2/totodore/automate/tags/list?last=1.1.0&n=2>; rel="prev"`:               "",
+		``:           "",
+		`rel="next"`: "",
+	}
+	for link, want := range tests {
+		if got := getNextPath(link, named); got != want {
+			t.Errorf("getNextPath(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestGetDigestPinned(t *testing.T) {
+	c := NewClient("", "")
+	digest, err := c.GetDigest(context.Background(), "totodore/automate@"+testDigest)
+	if err != nil || digest != testDigest {
+		t.Errorf("GetDigest() = %s %v, want %s", digest, err, testDigest)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:totodore/automate:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:totodore/automate:pull"},
+		},
+		{
+			`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:totodore/automate:pull,push"`,
+			"bearer",
+			map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io", "scope": "repository:totodore/automate:pull,push"},
+		},
+		{
+			`Bearer realm="https://auth.test/token", scope="repository:a:pull,push repository:b:pull", error="insufficient_scope"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.test/token", "scope": "repository:a:pull,push repository:b:pull", "error": "insufficient_scope"},
+		},
+		{`Basic realm="Registry Realm"`, "basic", map[string]string{"realm": "Registry Realm"}},
+		{`Basic realm=registry,charset=UTF-8`, "basic", map[string]string{"realm": "registry", "charset": "UTF-8"}},
+		{`Basic`, "basic", map[string]string{}},
+		{``, "", map[string]string{}},
+	}
+	for _, test := range tests {
+		scheme, params := parseChallenge(test.header)
+		if scheme != test.scheme || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("parseChallenge(%q) = %s %v, want %s %v", test.header, scheme, params, test.scheme, test.params)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const auditFile = "audit.log"
+
+//Maximum number of audit entries kept in memory to be queried
+const maxAuditEntries = 1000
+
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"` //login or token
+	Username string    `json:"username,omitempty"`
+	Ip       string    `json:"ip"`
+	Success  bool      `json:"success"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+//Filter of an audit log query, empty fields match every entry
+type AuditQuery struct {
+	Username string
+	Ip       string
+	Success  *bool
+	Since    time.Time
+	Limit    int
+}
+
+//Authentication attempts appended as JSON lines to the audit.log file of the conf directory
+//The most recent entries are kept in memory to be queried
+type AuditStore struct {
+	entries []AuditEntry
+	mutex   sync.RWMutex
+}
+
+func NewAuditStore() *AuditStore {
+	store := &AuditStore{entries: make([]AuditEntry, 0)}
+	file, err := os.Open(filepath.Join(Dir(), auditFile))
+	if os.IsNotExist(err) {
+		return store
+	} else if err != nil {
+		log.Fatal("Error while loading audit log:", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			store.append(entry)
+		}
+	}
+	return store
+}
+
+//Record an authentication attempt
+func (store *AuditStore) Record(entry AuditEntry) {
+	entry.Time = time.Now()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.append(entry)
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		log.Println("Error while writing audit log:", err)
+		return
+	}
+	file, err := os.OpenFile(filepath.Join(Dir(), auditFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("Error while writing audit log:", err)
+		return
+	}
+	defer file.Close()
+	data, _ := json.Marshal(entry)
+	file.Write(append(data, '\n'))
+}
+
+func (store *AuditStore) append(entry AuditEntry) {
+	store.entries = append(store.entries, entry)
+	if len(store.entries) > maxAuditEntries {
+		store.entries = store.entries[len(store.entries)-maxAuditEntries:]
+	}
+}
+
+//Get the entries matching a query, most recent first
+func (store *AuditStore) Query(query AuditQuery) []AuditEntry {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	entries := make([]AuditEntry, 0)
+	for i := len(store.entries) - 1; i >= 0; i-- {
+		entry := store.entries[i]
+		if (query.Username != "" && entry.Username != query.Username) ||
+			(query.Ip != "" && entry.Ip != query.Ip) ||
+			(query.Success != nil && entry.Success != *query.Success) ||
+			entry.Time.Before(query.Since) {
+			continue
+		}
+		entries = append(entries, entry)
+		if query.Limit > 0 && len(entries) >= query.Limit {
+			break
+		}
+	}
+	return entries
+}
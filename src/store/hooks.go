@@ -0,0 +1,55 @@
+package store
+
+import (
+	"crypto/subtle"
+	"dockerci/src/utils"
+	"log"
+	"sync"
+)
+
+const hooksFile = "hooks.json"
+
+//Length of the random key of the webhook urls
+const hookKeyLength = 32
+
+//Random keys of the webhook urls persisted in the hooks.json file of the conf directory
+//The map has the webhook name in key and the webhook key in value
+type HookKeyStore struct {
+	keys  map[string]string
+	mutex sync.RWMutex
+}
+
+func NewHookKeyStore() *HookKeyStore {
+	store := &HookKeyStore{keys: make(map[string]string)}
+	if err := loadJSON(hooksFile, &store.keys); err != nil {
+		log.Fatal("Error while loading webhook keys:", err)
+	}
+	return store
+}
+
+//Get the key of a webhook, a new one is generated and persisted on first call
+func (store *HookKeyStore) Ensure(name string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if key, ok := store.keys[name]; ok {
+		return key, nil
+	}
+	store.keys[name] = utils.RandStringRunes(hookKeyLength)
+	return store.keys[name], saveJSON(hooksFile, store.keys)
+}
+
+//Replace the key of a webhook, the former url stops working
+func (store *HookKeyStore) Rotate(name string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.keys[name] = utils.RandStringRunes(hookKeyLength)
+	return store.keys[name], saveJSON(hooksFile, store.keys)
+}
+
+//Check the key of a webhook in constant time
+func (store *HookKeyStore) Verify(name string, key string) bool {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	expected, ok := store.keys[name]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(key)) == 1
+}
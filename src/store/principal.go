@@ -0,0 +1,10 @@
+package store
+
+//Identity of an authenticated request: a dashboard user or an api token
+type Principal interface {
+	//Name of the principal used in logs
+	Identity() string
+	IsAdmin() bool
+	//Check if an action is allowed on a container from its webhook name and group
+	Can(action Action, name string, group string) bool
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//Get the directory in which docker-ci persists its data from CONF_DIR (./conf by default)
+func Dir() string {
+	if dir := os.Getenv("CONF_DIR"); dir != "" {
+		return dir
+	}
+	return "./conf"
+}
+
+//Load a JSON file of the conf directory into obj
+//A missing file is not an error, obj is then left untouched
+func loadJSON(name string, obj interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(Dir(), name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+//Write obj as JSON into a file of the conf directory
+//The file is written next to its destination and then renamed so that it is never half written
+func saveJSON(name string, obj interface{}) error {
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(obj, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(Dir(), name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionDeploy   Action = "deploy"   //Trigger a deployment
+	ActionStatus   Action = "status"   //Read containers and jobs
+	ActionRollback Action = "rollback" //Cancel a deployment and restore the former container
+)
+
+//Prefix of the api tokens, it distinguishes them from the dashboard JWT
+const TokenPrefix = "dci_"
+
+//Container scope giving access to every container
+const AllContainers = "*"
+
+const tokensFile = "tokens.json"
+
+var (
+	ErrInvalidToken  = errors.New("a token needs a name, at least one container and valid actions")
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+//Long-lived token for CI systems limited to some containers and actions
+//Only the sha256 hash of the token is stored, the token itself is shown once at creation
+type ApiToken struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash,omitempty"`
+	Containers []string  `json:"containers"`
+	Actions    []Action  `json:"actions"`
+	CreatedBy  string    `json:"createdBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+//Api tokens persisted in the tokens.json file of the conf directory
+type TokenStore struct {
+	tokens map[string]*ApiToken
+	mutex  sync.RWMutex
+}
+
+func NewTokenStore() *TokenStore {
+	store := &TokenStore{tokens: make(map[string]*ApiToken)}
+	tokens := make([]*ApiToken, 0)
+	if err := loadJSON(tokensFile, &tokens); err != nil {
+		log.Fatal("Error while loading api tokens:", err)
+	}
+	for _, token := range tokens {
+		store.tokens[token.Id] = token
+	}
+	return store
+}
+
+//Mint a new token and return its secret value, it cannot be retrieved afterwards
+func (store *TokenStore) Create(token ApiToken) (string, *ApiToken, error) {
+	if token.Name == "" || len(token.Containers) == 0 || len(token.Actions) == 0 {
+		return "", nil, ErrInvalidToken
+	}
+	for _, action := range token.Actions {
+		if action != ActionDeploy && action != ActionStatus && action != ActionRollback {
+			return "", nil, ErrInvalidToken
+		}
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	value := TokenPrefix + secret
+	token.Id = id
+	token.Hash = hashToken(value)
+	token.CreatedAt = time.Now()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.tokens[id] = &token
+	if err := store.save(); err != nil {
+		delete(store.tokens, id)
+		return "", nil, err
+	}
+	copy := token
+	copy.Hash = ""
+	return value, &copy, nil
+}
+
+//Get the token matching a secret value, nil if it doesn't exist or has been revoked
+func (store *TokenStore) Authenticate(value string) *ApiToken {
+	hash := hashToken(value)
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for _, token := range store.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			copy := *token
+			return &copy
+		}
+	}
+	return nil
+}
+
+//Get all the tokens sorted by creation date, without their hash
+func (store *TokenStore) List() []ApiToken {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	tokens := make([]ApiToken, 0, len(store.tokens))
+	for _, token := range store.tokens {
+		copy := *token
+		copy.Hash = ""
+		tokens = append(tokens, copy)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+//Revoke a token from its id
+func (store *TokenStore) Revoke(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.tokens[id]; !ok {
+		return ErrTokenNotFound
+	}
+	delete(store.tokens, id)
+	return store.save()
+}
+
+func (store *TokenStore) save() error {
+	tokens := make([]*ApiToken, 0, len(store.tokens))
+	for _, token := range store.tokens {
+		tokens = append(tokens, token)
+	}
+	return saveJSON(tokensFile, tokens)
+}
+
+func (token *ApiToken) Identity() string {
+	return "token " + token.Name
+}
+
+func (token *ApiToken) IsAdmin() bool {
+	return false
+}
+
+//Check if the token allows an action on a container from its webhook name
+func (token *ApiToken) Can(action Action, name string, group string) bool {
+	allowed := false
+	for _, a := range token.Actions {
+		allowed = allowed || a == action
+	}
+	if !allowed {
+		return false
+	}
+	for _, container := range token.Containers {
+		if container == AllContainers || container == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//Check if a value looks like an api token rather than a JWT
+func IsApiToken(value string) bool {
+	return strings.HasPrefix(value, TokenPrefix)
+}
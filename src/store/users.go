@@ -0,0 +1,171 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"    //Can deploy every container and manage users
+	RoleOperator Role = "operator" //Can only deploy the containers and groups it is allowed to
+)
+
+const usersFile = "users.json"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidUser        = errors.New("a user needs a username, a password and a valid role")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+type User struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"passwordHash,omitempty"`
+	Role         Role     `json:"role"`
+	Containers   []string `json:"containers,omitempty"` //Webhook names the user can deploy
+	Groups       []string `json:"groups,omitempty"`     //docker-ci.group labels the user can deploy
+}
+
+//Users persisted in the users.json file of the conf directory with bcrypt hashed passwords
+type UserStore struct {
+	users map[string]*User
+	mutex sync.RWMutex
+}
+
+//Hash compared when the user doesn't exist so that the response time doesn't reveal existing usernames
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("docker-ci"), bcrypt.DefaultCost)
+
+//Load the user store
+//If no user is defined and PASSWORD is set, an admin user named admin is created with this password
+func NewUserStore() *UserStore {
+	store := &UserStore{users: make(map[string]*User)}
+	users := make([]*User, 0)
+	if err := loadJSON(usersFile, &users); err != nil {
+		log.Fatal("Error while loading users:", err)
+	}
+	for _, user := range users {
+		store.users[user.Username] = user
+	}
+	if password := os.Getenv("PASSWORD"); len(store.users) == 0 && password != "" {
+		if err := store.Save(User{Username: "admin", Role: RoleAdmin}, password); err != nil {
+			log.Fatal("Error while creating admin user:", err)
+		}
+		log.Println("Admin user created from PASSWORD")
+	}
+	return store
+}
+
+//Check the credentials of a user and return it
+func (store *UserStore) Authenticate(username string, password string) (*User, error) {
+	user := store.Get(username)
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+//Get a copy of a user from its username, nil if it doesn't exist
+func (store *UserStore) Get(username string) *User {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	user, ok := store.users[username]
+	if !ok {
+		return nil
+	}
+	copy := *user
+	return &copy
+}
+
+//Get all the users sorted by username, without their password hash
+func (store *UserStore) List() []User {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	users := make([]User, 0, len(store.users))
+	for _, user := range store.users {
+		copy := *user
+		copy.PasswordHash = ""
+		users = append(users, copy)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+//Create or update a user
+//If the password is empty the password of an existing user is kept
+func (store *UserStore) Save(user User, password string) error {
+	if user.Username == "" || (user.Role != RoleAdmin && user.Role != RoleOperator) {
+		return ErrInvalidUser
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = string(hash)
+	} else if existing, ok := store.users[user.Username]; ok {
+		user.PasswordHash = existing.PasswordHash
+	} else {
+		return ErrInvalidUser
+	}
+	store.users[user.Username] = &user
+	return store.save()
+}
+
+//Delete a user
+func (store *UserStore) Delete(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.users[username]; !ok {
+		return ErrUserNotFound
+	}
+	delete(store.users, username)
+	return store.save()
+}
+
+func (store *UserStore) save() error {
+	users := make([]*User, 0, len(store.users))
+	for _, user := range store.users {
+		users = append(users, user)
+	}
+	return saveJSON(usersFile, users)
+}
+
+func (user *User) Identity() string {
+	return "user " + user.Username
+}
+
+func (user *User) IsAdmin() bool {
+	return user.Role == RoleAdmin
+}
+
+//Check if the user can act on a container from its webhook name and group
+//Admins can act on every container, operators on the containers and groups they are allowed to
+func (user *User) Can(action Action, name string, group string) bool {
+	if user.Role == RoleAdmin {
+		return true
+	}
+	for _, container := range user.Containers {
+		if container == name {
+			return true
+		}
+	}
+	for _, g := range user.Groups {
+		if group != "" && g == group {
+			return true
+		}
+	}
+	return false
+}
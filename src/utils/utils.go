@@ -1,9 +1,10 @@
 package utils
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"io"
-	"math/rand"
+	"math/big"
 )
 
 func InterfaceToStringSlice(params []interface{}) []string {
@@ -23,10 +24,16 @@ func InterfaceToStringSlice(params []interface{}) []string {
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
+//Generate a random string of letters from a cryptographically secure source
 func RandStringRunes(n int) string {
 	b := make([]rune, n)
+	max := big.NewInt(int64(len(letterRunes)))
 	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = letterRunes[index.Int64()]
 	}
 	return string(b)
 }